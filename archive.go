@@ -0,0 +1,100 @@
+package main
+
+import (
+    "archive/tar"
+    "archive/zip"
+    "compress/gzip"
+    "fmt"
+    "os"
+    "path/filepath"
+    "time"
+)
+
+// archiveFileNames maps each --archive format to the file written in the
+// output folder
+var archiveFileNames = map[string]string{
+    "zip":   "merged.zip",
+    "targz": "merged.tar.gz",
+}
+
+// outputArchive receives the finished output files in place of the output
+// folder
+type outputArchive interface {
+    add(name string, content []byte) error
+    Close() error
+}
+
+func createArchive(outputFolder string, format string) (outputArchive, error) {
+    fileName, ok := archiveFileNames[format]
+    if !ok {
+        return nil, fmt.Errorf("unknown archive format %q (expected \"zip\" or \"targz\")", format)
+    }
+
+    file, err := os.Create(filepath.Join(outputFolder, fileName))
+    if err != nil {
+        return nil, err
+    }
+
+    if format == "zip" {
+        return &zipArchive{file: file, writer: zip.NewWriter(file)}, nil
+    }
+    gz := gzip.NewWriter(file)
+    return &tarGzArchive{file: file, gz: gz, writer: tar.NewWriter(gz)}, nil
+}
+
+type zipArchive struct {
+    file   *os.File
+    writer *zip.Writer
+}
+
+func (a *zipArchive) add(name string, content []byte) error {
+    entry, err := a.writer.CreateHeader(&zip.FileHeader{
+        Name:     name,
+        Method:   zip.Deflate,
+        Modified: time.Now(),
+    })
+    if err != nil {
+        return err
+    }
+    _, err = entry.Write(content)
+    return err
+}
+
+func (a *zipArchive) Close() error {
+    err := a.writer.Close()
+    if closeErr := a.file.Close(); err == nil {
+        err = closeErr
+    }
+    return err
+}
+
+type tarGzArchive struct {
+    file   *os.File
+    gz     *gzip.Writer
+    writer *tar.Writer
+}
+
+func (a *tarGzArchive) add(name string, content []byte) error {
+    err := a.writer.WriteHeader(&tar.Header{
+        Name:    name,
+        Mode:    0644,
+        Size:    int64(len(content)),
+        ModTime: time.Now(),
+    })
+    if err != nil {
+        return err
+    }
+    _, err = a.writer.Write(content)
+    return err
+}
+
+func (a *tarGzArchive) Close() error {
+    err := a.writer.Close()
+    if gzErr := a.gz.Close(); err == nil {
+        err = gzErr
+    }
+    if closeErr := a.file.Close(); err == nil {
+        err = closeErr
+    }
+    return err
+}
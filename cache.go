@@ -0,0 +1,107 @@
+package main
+
+import (
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "fmt"
+    "os"
+    "path/filepath"
+)
+
+const (
+    cacheFileName      = ".filemerge-cache.json"
+    mirrorListFileName = ".filemerge-mirror.json"
+)
+
+// mergeCache records which source files went into each output file, so an
+// incremental run can leave output files with unchanged sources alone
+type mergeCache struct {
+    Settings string                   `json:"settings"`
+    Outputs  map[string][]cachedSource `json:"outputs"`
+}
+
+type cachedSource struct {
+    Path    string `json:"path"`
+    SHA256  string `json:"sha256"`
+    ModTime int64  `json:"mod_time,omitempty"` // only kept when it shows up in headers
+}
+
+// loadCache reads the cache of a previous incremental run. ok is false when
+// there is none.
+func loadCache(outputFolder string) (cache mergeCache, ok bool, err error) {
+    content, err := os.ReadFile(filepath.Join(outputFolder, cacheFileName))
+    if os.IsNotExist(err) {
+        return mergeCache{}, false, nil
+    }
+    if err != nil {
+        return mergeCache{}, false, err
+    }
+    if err := json.Unmarshal(content, &cache); err != nil {
+        return mergeCache{}, false, err
+    }
+    return cache, true, nil
+}
+
+func writeCache(outputFolder string, cache mergeCache) error {
+    content, err := json.MarshalIndent(cache, "", "  ")
+    if err != nil {
+        return err
+    }
+    return os.WriteFile(filepath.Join(outputFolder, cacheFileName), append(content, '\n'), 0644)
+}
+
+// settingsHash fingerprints the config, since most settings change how the
+// output is written even when the sources stay the same
+func settingsHash(config Config) string {
+    content, _ := json.Marshal(config)
+    hash := sha256.Sum256(content)
+    return hex.EncodeToString(hash[:])
+}
+
+// upToDate reports whether the named output file exists and was built from
+// exactly the given sources
+func (c mergeCache) upToDate(outputFolder string, name string, sources []cachedSource) bool {
+    previous, ok := c.Outputs[name]
+    if !ok || len(previous) != len(sources) {
+        return false
+    }
+    for i := range sources {
+        if previous[i] != sources[i] {
+            return false
+        }
+    }
+    _, err := os.Stat(filepath.Join(outputFolder, name))
+    return err == nil
+}
+
+// loadMirrorList returns the slash-separated paths of the files the last
+// mirror run wrote below outputFolder, so they can be told apart from
+// files that were already there
+func loadMirrorList(outputFolder string) (map[string]bool, error) {
+    mirrored := map[string]bool{}
+    content, err := os.ReadFile(filepath.Join(outputFolder, mirrorListFileName))
+    if os.IsNotExist(err) {
+        return mirrored, nil
+    }
+    if err != nil {
+        return nil, err
+    }
+
+    var names []string
+    if err := json.Unmarshal(content, &names); err != nil {
+        return nil, fmt.Errorf("reading %s: %w", mirrorListFileName, err)
+    }
+    for _, name := range names {
+        mirrored[name] = true
+    }
+    return mirrored, nil
+}
+
+func writeMirrorList(outputFolder string, names []string) error {
+    content, err := json.MarshalIndent(names, "", "  ")
+    if err != nil {
+        return err
+    }
+    return os.WriteFile(filepath.Join(outputFolder, mirrorListFileName), append(content, '\n'), 0644)
+}
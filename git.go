@@ -0,0 +1,169 @@
+package main
+
+import (
+    "bytes"
+    "fmt"
+    "io/fs"
+    "os/exec"
+    "path"
+    "path/filepath"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// gitFileInfo describes a file or folder of a git tree, so a ref can be
+// walked like the working tree
+type gitFileInfo struct {
+    name    string
+    size    int64
+    dir     bool
+    modTime time.Time
+}
+
+func (i gitFileInfo) Name() string       { return i.name }
+func (i gitFileInfo) Size() int64        { return i.size }
+func (i gitFileInfo) ModTime() time.Time { return i.modTime }
+func (i gitFileInfo) IsDir() bool        { return i.dir }
+func (i gitFileInfo) Sys() any           { return nil }
+
+func (i gitFileInfo) Mode() fs.FileMode {
+    if i.dir {
+        return fs.ModeDir | 0755
+    }
+    return 0644
+}
+
+// walkGitRef calls fn for the folders and files tracked below project at
+// ref, in the order filepath.Walk would. Symlinks and submodules are left
+// out. Every entry carries the commit time as its modification time.
+func walkGitRef(project string, ref string, fn filepath.WalkFunc) error {
+    commitTime, err := gitOutput(project, "log", "-1", "--format=%ct", ref, "--")
+    if err != nil {
+        return err
+    }
+    seconds, err := strconv.ParseInt(strings.TrimSpace(string(commitTime)), 10, 64)
+    if err != nil {
+        return fmt.Errorf("reading commit time of %s: %w", ref, err)
+    }
+    modTime := time.Unix(seconds, 0)
+
+    // Paths are listed relative to the project, even within a larger repository
+    listing, err := gitOutput(project, "ls-tree", "-r", "-l", "-z", ref)
+    if err != nil {
+        return err
+    }
+
+    entries := map[string]gitFileInfo{}
+    for _, record := range strings.Split(string(listing), "\x00") {
+        meta, relPath, ok := strings.Cut(record, "\t")
+        fields := strings.Fields(meta)
+        if !ok || len(fields) != 4 || fields[1] != "blob" || fields[0] == "120000" {
+            continue
+        }
+        size, _ := strconv.ParseInt(fields[3], 10, 64)
+        entries[relPath] = gitFileInfo{name: path.Base(relPath), size: size, modTime: modTime}
+        for dir := path.Dir(relPath); dir != "."; dir = path.Dir(dir) {
+            entries[dir] = gitFileInfo{name: path.Base(dir), dir: true, modTime: modTime}
+        }
+    }
+
+    // Sorting with "/" below every other byte visits folders before their
+    // siblings, as filepath.Walk does
+    relPaths := make([]string, 0, len(entries))
+    for relPath := range entries {
+        relPaths = append(relPaths, relPath)
+    }
+    sort.Slice(relPaths, func(i, j int) bool {
+        return strings.ReplaceAll(relPaths[i], "/", "\x00") < strings.ReplaceAll(relPaths[j], "/", "\x00")
+    })
+
+    root := gitFileInfo{name: filepath.Base(project), dir: true, modTime: modTime}
+    if err := fn(project, root, nil); err != nil {
+        if err == filepath.SkipDir {
+            return nil
+        }
+        return err
+    }
+
+    skipped := "" // folder whose contents are being skipped
+    for _, relPath := range relPaths {
+        if skipped != "" && strings.HasPrefix(relPath, skipped+"/") {
+            continue
+        }
+        info := entries[relPath]
+        err := fn(filepath.Join(project, filepath.FromSlash(relPath)), info, nil)
+        if err == filepath.SkipDir && info.dir {
+            skipped = relPath
+        } else if err != nil && err != filepath.SkipDir {
+            return err
+        }
+    }
+    return nil
+}
+
+// readGitFile returns the content of a file below project at ref
+func readGitFile(project string, ref string, relPath string) ([]byte, error) {
+    return gitOutput(project, "show", ref+":./"+filepath.ToSlash(relPath))
+}
+
+// changedFiles returns the slash-separated paths, relative to project, of
+// files changed between since and ref (the working tree when ref is empty).
+// Deleted files are left out.
+func changedFiles(project string, since string, ref string) (map[string]bool, error) {
+    args := []string{"diff", "--name-only", "--relative", "--diff-filter=d", "-z", since}
+    if ref != "" {
+        args = append(args, ref)
+    }
+    output, err := gitOutput(project, append(args, "--")...)
+    if err != nil {
+        return nil, err
+    }
+
+    return splitPathList(output), nil
+}
+
+// splitPathList turns the NUL-separated output of git -z into a set
+func splitPathList(output []byte) map[string]bool {
+    paths := map[string]bool{}
+    for _, relPath := range strings.Split(string(output), "\x00") {
+        if relPath != "" {
+            paths[relPath] = true
+        }
+    }
+    return paths
+}
+
+// trackedFiles returns the slash-separated paths, relative to project, of
+// the files git tracks. It returns nil when project isn't in a git
+// repository or git isn't installed.
+func trackedFiles(project string) (map[string]bool, error) {
+    if _, err := exec.LookPath("git"); err != nil {
+        return nil, nil
+    }
+    if _, err := gitOutput(project, "rev-parse", "--is-inside-work-tree"); err != nil {
+        return nil, nil
+    }
+
+    output, err := gitOutput(project, "ls-files", "-z")
+    if err != nil {
+        return nil, err
+    }
+    return splitPathList(output), nil
+}
+
+func gitOutput(dir string, args ...string) ([]byte, error) {
+    cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+
+    var stderr bytes.Buffer
+    cmd.Stderr = &stderr
+    output, err := cmd.Output()
+    if err != nil {
+        if message := strings.TrimSpace(stderr.String()); message != "" {
+            return nil, fmt.Errorf("git %s: %s", args[0], message)
+        }
+        return nil, fmt.Errorf("git %s: %w", args[0], err)
+    }
+    return output, nil
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+    "bufio"
+    "os"
+    "path/filepath"
+    "strings"
+)
+
+// pattern is a single gitignore-style rule. base is the slash-separated
+// directory (relative to the matcher root) of the file it was read from.
+type pattern struct {
+    base    string
+    glob    string
+    negate  bool
+    dirOnly bool
+}
+
+type ignoreMatcher struct {
+    root      string
+    fileNames []string
+    patterns  []pattern
+    loaded    map[string]bool
+}
+
+// loadIgnoreMatcher reads the fileNames files within projectRoot, such as
+// .gitignore and .dockerignore, after the given patterns that apply to the
+// whole project
+func loadIgnoreMatcher(projectRoot string, fileNames []string, patterns []pattern) (*ignoreMatcher, error) {
+    matcher := &ignoreMatcher{
+        root:      projectRoot,
+        fileNames: fileNames,
+        patterns:  patterns,
+        loaded:    map[string]bool{},
+    }
+    if err := matcher.loadDir(projectRoot); err != nil {
+        return nil, err
+    }
+    return matcher, nil
+}
+
+// loadIgnoreFile reads a single ignore file whose patterns are relative to
+// the project being merged. A missing file has no patterns.
+func loadIgnoreFile(path string) ([]pattern, error) {
+    patterns, err := parseIgnoreFile(path, ".")
+    if os.IsNotExist(err) {
+        return nil, nil
+    }
+    return patterns, err
+}
+
+// loadDir reads the ignore files of a directory, if present. Directories are
+// loaded as the walk reaches them, so deeper rules are appended after (and
+// take precedence over) the rules of their parents.
+func (m *ignoreMatcher) loadDir(dir string) error {
+    if m.loaded[dir] {
+        return nil
+    }
+    m.loaded[dir] = true
+
+    base, err := filepath.Rel(m.root, dir)
+    if err != nil {
+        return err
+    }
+
+    for _, fileName := range m.fileNames {
+        patterns, err := parseIgnoreFile(filepath.Join(dir, fileName), filepath.ToSlash(base))
+        if err != nil {
+            if os.IsNotExist(err) {
+                continue
+            }
+            return err
+        }
+        m.patterns = append(m.patterns, patterns...)
+    }
+    return nil
+}
+
+func (m *ignoreMatcher) isIgnored(path string, isDir bool) bool {
+    relPath, err := filepath.Rel(m.root, path)
+    if err != nil || relPath == "." {
+        return false
+    }
+    relPath = filepath.ToSlash(relPath)
+
+    // The last matching rule wins, so negations can re-include paths
+    ignored := false
+    for _, p := range m.patterns {
+        if p.matches(relPath, isDir) {
+            ignored = !p.negate
+        }
+    }
+    return ignored
+}
+
+func (p pattern) matches(relPath string, isDir bool) bool {
+    if p.dirOnly && !isDir {
+        return false
+    }
+
+    if p.base != "." {
+        if !strings.HasPrefix(relPath, p.base+"/") {
+            return false
+        }
+        relPath = strings.TrimPrefix(relPath, p.base+"/")
+    }
+
+    return matchGlob(p.glob, relPath)
+}
+
+func parseIgnoreFile(path string, base string) ([]pattern, error) {
+    file, err := os.Open(path)
+    if err != nil {
+        return nil, err
+    }
+    defer file.Close()
+
+    var patterns []pattern
+    scanner := bufio.NewScanner(file)
+    for scanner.Scan() {
+        if p, ok := parsePattern(scanner.Text(), base); ok {
+            patterns = append(patterns, p)
+        }
+    }
+
+    return patterns, scanner.Err()
+}
+
+func parsePattern(line string, base string) (pattern, bool) {
+    line = strings.TrimRight(line, " \t\r")
+    if line == "" || strings.HasPrefix(line, "#") {
+        return pattern{}, false
+    }
+
+    p := pattern{base: base}
+    if strings.HasPrefix(line, "!") {
+        p.negate = true
+        line = line[1:]
+    } else if strings.HasPrefix(line, "\\") {
+        // Escaped leading "#" or "!"
+        line = line[1:]
+    }
+
+    if strings.HasSuffix(line, "/") {
+        p.dirOnly = true
+        line = strings.TrimSuffix(line, "/")
+    }
+
+    // Patterns without a slash match at any depth, others are anchored
+    if strings.Contains(line, "/") {
+        line = strings.TrimPrefix(line, "/")
+    } else {
+        line = "**/" + line
+    }
+
+    if line == "" || line == "**/" {
+        return pattern{}, false
+    }
+
+    p.glob = line
+    return p, true
+}
+
+// matchGlob matches a slash-separated path against a glob where "**"
+// spans any number of path segments.
+func matchGlob(glob string, path string) bool {
+    return matchSegments(strings.Split(glob, "/"), strings.Split(path, "/"))
+}
+
+func matchSegments(glob []string, path []string) bool {
+    for len(glob) > 0 {
+        if glob[0] == "**" {
+            // A trailing "**" matches everything inside, but not the parent itself
+            if len(glob) == 1 {
+                return len(path) > 0
+            }
+            for i := 0; i <= len(path); i++ {
+                if matchSegments(glob[1:], path[i:]) {
+                    return true
+                }
+            }
+            return false
+        }
+
+        if len(path) == 0 {
+            return false
+        }
+        if ok, _ := filepath.Match(glob[0], path[0]); !ok {
+            return false
+        }
+        glob, path = glob[1:], path[1:]
+    }
+    return len(path) == 0
+}
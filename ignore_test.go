@@ -0,0 +1,91 @@
+package main
+
+import (
+    "path/filepath"
+    "testing"
+)
+
+// matcherFor builds an ignore matcher from the lines of a single ignore
+// file in the project root
+func matcherFor(root string, lines ...string) *ignoreMatcher {
+    matcher := &ignoreMatcher{root: root, loaded: map[string]bool{}}
+    for _, line := range lines {
+        if p, ok := parsePattern(line, "."); ok {
+            matcher.patterns = append(matcher.patterns, p)
+        }
+    }
+    return matcher
+}
+
+func TestIgnoreMatcher(t *testing.T) {
+    root := filepath.FromSlash("/project")
+    tests := []struct {
+        name    string
+        lines   []string
+        relPath string
+        isDir   bool
+        want    bool
+    }{
+        {"unanchored matches at any depth", []string{"build"}, "src/build", true, true},
+        {"unanchored matches in root", []string{"build"}, "build", true, true},
+        {"anchored matches in root", []string{"/build"}, "build", true, true},
+        {"anchored skips nested", []string{"/build"}, "src/build", true, false},
+        {"pattern with slash is anchored", []string{"src/gen"}, "lib/src/gen", true, false},
+        {"dir pattern matches folder", []string{"logs/"}, "logs", true, true},
+        {"dir pattern skips file", []string{"logs/"}, "logs", false, false},
+        {"glob in segment", []string{"*.log"}, "logs/a.log", false, true},
+        {"leading double star", []string{"**/gen"}, "a/b/gen", true, true},
+        {"middle double star", []string{"a/**/b"}, "a/x/y/b", false, true},
+        {"middle double star matches no folders", []string{"a/**/b"}, "a/b", false, true},
+        {"trailing double star matches contents", []string{"gen/**"}, "gen/x.ts", false, true},
+        {"trailing double star skips folder itself", []string{"gen/**"}, "gen", true, false},
+        {"negation re-includes", []string{"logs/*", "!logs/keep.log"}, "logs/keep.log", false, false},
+        {"negation leaves others ignored", []string{"logs/*", "!logs/keep.log"}, "logs/a.log", false, true},
+        {"last match wins", []string{"!keep.log", "*.log"}, "keep.log", false, true},
+        {"excluded parent stays excluded", []string{"logs/", "!logs/keep.log"}, "logs", true, true},
+        {"escaped hash", []string{`\#notes`}, "#notes", false, true},
+        {"comment line", []string{"#notes"}, "#notes", false, false},
+        {"escaped bang is not a negation", []string{`\!important`}, "!important", false, true},
+        {"root is never ignored", []string{"*"}, ".", true, false},
+    }
+
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            matcher := matcherFor(root, test.lines...)
+            path := filepath.Join(root, filepath.FromSlash(test.relPath))
+            if got := matcher.isIgnored(path, test.isDir); got != test.want {
+                t.Errorf("isIgnored(%q) with %q = %v, want %v", test.relPath, test.lines, got, test.want)
+            }
+        })
+    }
+}
+
+func TestParsePatternSkipsBlankAndComments(t *testing.T) {
+    for _, line := range []string{"", "   ", "# comment", "/", "!"} {
+        if p, ok := parsePattern(line, "."); ok {
+            t.Errorf("parsePattern(%q) = %+v, want no pattern", line, p)
+        }
+    }
+}
+
+func TestMatchGlob(t *testing.T) {
+    tests := []struct {
+        glob string
+        path string
+        want bool
+    }{
+        {"**/*.ts", "index.ts", true},
+        {"**/*.ts", "src/lib/index.ts", true},
+        {"src/*.ts", "src/lib/index.ts", false},
+        {"src/**/*.ts", "src/index.ts", true},
+        {"src/**", "src", false},
+        {"a?c", "abc", true},
+        {"[ab]c", "cc", false},
+    }
+
+    for _, test := range tests {
+        if got := matchGlob(test.glob, test.path); got != test.want {
+            t.Errorf("matchGlob(%q, %q) = %v, want %v", test.glob, test.path, got, test.want)
+        }
+    }
+}
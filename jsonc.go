@@ -0,0 +1,65 @@
+package main
+
+import (
+    "bytes"
+    "fmt"
+)
+
+// stripJSONComments turns JSONC (JSON with comments) into plain JSON by
+// blanking out "//" and "/* */" comments and trailing commas. Blanked bytes
+// become spaces, so offsets in decode errors still point at the right spot.
+// Plain JSON passes through unchanged. A "/*" comment that is never closed
+// is an error.
+func stripJSONComments(content []byte) ([]byte, error) {
+    out := make([]byte, len(content))
+    copy(out, content)
+
+    inString := false
+    lastComma := -1 // position of a comma that may turn out to be trailing
+    for i := 0; i < len(out); i++ {
+        c := out[i]
+        if inString {
+            if c == '\\' {
+                i++
+            } else if c == '"' {
+                inString = false
+            }
+            continue
+        }
+
+        switch {
+        case c == '"':
+            inString = true
+            lastComma = -1
+        case c == '/' && i+1 < len(out) && out[i+1] == '/':
+            for ; i < len(out) && out[i] != '\n'; i++ {
+                out[i] = ' '
+            }
+        case c == '/' && i+1 < len(out) && out[i+1] == '*':
+            end := i + 2
+            for end < len(out) && !(out[end] == '*' && end+1 < len(out) && out[end+1] == '/') {
+                end++
+            }
+            if end == len(out) {
+                return nil, fmt.Errorf("unterminated /* comment on line %d", bytes.Count(out[:i], []byte("\n"))+1)
+            }
+            end += 2
+            for ; i < end; i++ {
+                if out[i] != '\n' {
+                    out[i] = ' '
+                }
+            }
+            i--
+        case c == ',':
+            lastComma = i
+        case c == '}' || c == ']':
+            if lastComma >= 0 {
+                out[lastComma] = ' '
+            }
+            lastComma = -1
+        case c != ' ' && c != '\t' && c != '\r' && c != '\n':
+            lastComma = -1
+        }
+    }
+    return out, nil
+}
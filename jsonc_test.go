@@ -0,0 +1,53 @@
+package main
+
+import (
+    "encoding/json"
+    "testing"
+)
+
+func TestStripJSONComments(t *testing.T) {
+    tests := []struct {
+        name  string
+        input string
+        want  string
+    }{
+        {"plain JSON", `{"a": 1}`, `{"a": 1}`},
+        {"line comment", "{\"a\": 1} // note", "{\"a\": 1}        "},
+        {"block comment", `{/* x */"a": 1}`, `{       "a": 1}`},
+        {"block comment keeps newlines", "{/* x\ny */\"a\": 1}", "{    \n    \"a\": 1}"},
+        {"line comment marker in string", `{"url": "http://x"}`, `{"url": "http://x"}`},
+        {"block comment marker in string", `{"glob": "src/*.ts", "b": "*/"}`, `{"glob": "src/*.ts", "b": "*/"}`},
+        {"escaped quote in string", `{"a": "say \"// hi\""}`, `{"a": "say \"// hi\""}`},
+        {"escaped backslash before quote", `{"a": "c:\\"} // x`, `{"a": "c:\\"}     `},
+        {"trailing comma in object", `{"a": 1,}`, `{"a": 1 }`},
+        {"trailing comma in array", `["a", "b",  ]`, `["a", "b"   ]`},
+        {"trailing comma before comment", "[1, // one\n]", "[1        \n]"},
+        {"comma in string is kept", `{"a": ",", "b": ","}`, `{"a": ",", "b": ","}`},
+    }
+
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            got, err := stripJSONComments([]byte(test.input))
+            if err != nil {
+                t.Fatalf("stripJSONComments(%q) returned error: %v", test.input, err)
+            }
+            if string(got) != test.want {
+                t.Errorf("stripJSONComments(%q) = %q, want %q", test.input, got, test.want)
+            }
+            if len(got) != len(test.input) {
+                t.Errorf("length changed from %d to %d", len(test.input), len(got))
+            }
+            if !json.Valid(got) {
+                t.Errorf("result %q isn't valid JSON", got)
+            }
+        })
+    }
+}
+
+func TestStripJSONCommentsUnterminated(t *testing.T) {
+    for _, input := range []string{`{"a": 1} /* never closed`, "{\n/* x *\n}", `{"a": 1} /*/`} {
+        if got, err := stripJSONComments([]byte(input)); err == nil {
+            t.Errorf("stripJSONComments(%q) = %q, want an error", input, got)
+        }
+    }
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+    "fmt"
+    "io"
+    "os"
+    "time"
+)
+
+type logLevel int
+
+const (
+    levelError logLevel = iota
+    levelWarn
+    levelInfo
+    levelDebug
+)
+
+// currentLogLevel is raised by --verbose and lowered by --quiet
+var currentLogLevel = levelInfo
+
+// logOutput receives progress and status messages. It moves to stderr when
+// stdout carries the result itself, such as with --stdout or --list.
+var logOutput io.Writer = os.Stdout
+
+// warningsLog receives warnings with a timestamp instead of the console when
+// warnings_log is set. warningCount tracks how many were written there.
+var (
+    warningsLog  io.Writer
+    warningCount int
+)
+
+func logf(level logLevel, format string, args ...any) {
+    if level == levelWarn && warningsLog != nil {
+        fmt.Fprintf(warningsLog, "%s Warning: %s\n", time.Now().Format(time.RFC3339), fmt.Sprintf(format, args...))
+        warningCount++
+        return
+    }
+    if level > currentLogLevel {
+        return
+    }
+
+    message := fmt.Sprintf(format, args...)
+    switch level {
+    case levelError:
+        message = colorize(ansiRed, "Error: "+message)
+    case levelWarn:
+        message = colorize(ansiYellow, "Warning: "+message)
+    }
+    clearProgress()
+    fmt.Fprintln(logOutput, message)
+}
+
+func errorf(format string, args ...any) { logf(levelError, format, args...) }
+func warnf(format string, args ...any)  { logf(levelWarn, format, args...) }
+func infof(format string, args ...any)  { logf(levelInfo, format, args...) }
+func debugf(format string, args ...any) { logf(levelDebug, format, args...) }
+
+const (
+    ansiReset  = "\x1b[0m"
+    ansiBold   = "\x1b[1m"
+    ansiRed    = "\x1b[31m"
+    ansiGreen  = "\x1b[32m"
+    ansiYellow = "\x1b[33m"
+)
+
+// colorize wraps text in an ANSI color when log output goes to a terminal
+// and NO_COLOR isn't set
+func colorize(code string, text string) string {
+    file, ok := logOutput.(*os.File)
+    if !ok || os.Getenv("NO_COLOR") != "" || !isTerminal(file) {
+        return text
+    }
+    return code + text + ansiReset
+}
+
+// The progress line is redrawn in place on stderr. Log messages clear it
+// first and the next update draws it again below them.
+var (
+    progressShown   bool
+    progressUpdated time.Time
+)
+
+func showProgress(done, total int, path string) {
+    if done < total && time.Since(progressUpdated) < 100*time.Millisecond {
+        return
+    }
+    progressUpdated = time.Now()
+
+    if len(path) > 60 {
+        path = "..." + path[len(path)-57:]
+    }
+    fmt.Fprintf(os.Stderr, "\r\x1b[K[%d/%d] %s", done, total, path)
+    progressShown = true
+}
+
+func clearProgress() {
+    if progressShown {
+        fmt.Fprint(os.Stderr, "\r\x1b[K")
+        progressShown = false
+    }
+}
@@ -2,234 +2,3407 @@ package main
 
 import (
     "bufio"
+    "bytes"
+    "context"
+    "crypto/sha256"
+    "encoding/hex"
     "encoding/json"
+    "errors"
     "fmt"
+    "io"
     "io/fs"
     "os"
     "os/exec"
+    "os/signal"
+    "os/user"
     "path/filepath"
+    "reflect"
+    "regexp"
+    "runtime"
+    "runtime/debug"
+    "runtime/pprof"
+    "slices"
+    "sort"
     "strings"
+    "text/template"
+    "time"
+    "unicode/utf8"
     "flag"
 )
 
 type Config struct {
     RootFolder        string   `json:"root_folder"`
+    RootFolders       []string `json:"root_folders"`
     OutputFolder      string   `json:"output_folder"`
     MaxFileSizeMB     int      `json:"max_file_size_mb"`
     BlacklistedFolders []string `json:"blacklisted_folders"`
     IgnoredFileTypes  []string `json:"ignored_file_types"`
+    ProjectMarkers    []string `json:"project_markers"`
+    RespectGitignore  bool     `json:"respect_gitignore"`
+    RespectIgnoreFiles []string `json:"respect_ignore_files"` // more gitignore-style files, such as ".dockerignore"
+    IncludePatterns   []string `json:"include_patterns"`
+    OutputFormat      string   `json:"output_format"`
+    MaxTokensPerFile  int      `json:"max_tokens_per_file"`
+    Workers           int      `json:"workers"`
+    SkipBinaryFiles   bool     `json:"skip_binary_files"`
+    NormalizeLineEndings bool `json:"normalize_line_endings"`
+    OutputFilePattern string   `json:"output_file_pattern"`
+    IncludeRootFiles  []string `json:"include_root_files"`
+    IncludeTree       bool     `json:"include_tree"`
+    MaxSourceFileKB   int      `json:"max_source_file_kb"`
+    DeduplicateContent bool    `json:"deduplicate_content"`
+    ScanDepth         int      `json:"scan_depth"`
+    HeaderMetadata    bool     `json:"header_metadata"`
+    ExcludeRegex      []string `json:"exclude_regex"`
+    FollowSymlinks    bool     `json:"follow_symlinks"`
+    SplitStrategy     string   `json:"split_strategy"`
+    FileSeparator     string   `json:"file_separator"`
+    HeaderTemplate    string   `json:"header_template"`
+    AllowedFileTypes  []string `json:"allowed_file_types"`
+    SortBy            string   `json:"sort_by"`
+    MaxLinesPerFile   int      `json:"max_lines_per_file"`
+    SkipInvalidUTF8   bool     `json:"skip_invalid_utf8"`
+    ReplaceInvalidUTF8 bool    `json:"replace_invalid_utf8"` // takes precedence over skip_invalid_utf8
+    MaxTotalSizeMB    int      `json:"max_total_size_mb"`
+    HeaderPathStyle   string   `json:"header_path_style"`
+    PostMergeCommand  string   `json:"post_merge_command"`
+    ContentMatch      string   `json:"content_match"`
+    Preamble          string   `json:"preamble"`
+    PreambleFile      string   `json:"preamble_file"`
+    LanguageMap       map[string]string `json:"language_map"` // extension to markdown language
+    TrimTrailingWhitespace bool `json:"trim_trailing_whitespace"`
+    CollapseBlankLines bool    `json:"collapse_blank_lines"`
+    Transforms        map[string]string `json:"transforms"` // extension to a command filtering the content
+    MinSourceFileBytes int     `json:"min_source_file_bytes"`
+    Editor            string   `json:"editor"` // used by --open, defaults to $EDITOR
+    SplitLargeFiles   bool     `json:"split_large_files"`
+    SkipEmptyFiles    bool     `json:"skip_empty_files"`
+    MaxOutputFiles    int      `json:"max_output_files"`
+    ReadRetries       int      `json:"read_retries"`
+    Footer            string   `json:"footer"`
+    FooterFile        string   `json:"footer_file"`
+    FooterSummary     bool     `json:"footer_summary"` // list the merged files after the footer
+    CleanOnlyGenerated bool    `json:"clean_only_generated"`
+    PerExtensionMaxKB map[string]int `json:"per_extension_max_kb"` // extension to a size its files are truncated at
+    MaxFilesPerDir    int      `json:"max_files_per_dir"`
+    FzfArgs           []string `json:"fzf_args"` // extra fzf options, such as "--height=40%"
+    TrackedOnly       bool     `json:"tracked_only"`
+    WarningsLog       string   `json:"warnings_log"` // file warnings are appended to instead of the console
+    CaseInsensitive   bool     `json:"case_insensitive"` // match extensions and blacklisted folders ignoring case
+    LineNumbers       bool     `json:"line_numbers"`
+    Sections          []Section `json:"sections"`
+    DefaultSection    string   `json:"default_section"` // section for files matching no section, which are dropped otherwise
+    IgnoreExtensionDirs bool   `json:"ignore_extension_dirs"` // ignored_file_types normally only apply to files
+    SkipHidden        bool     `json:"skip_hidden"` // skip files and folders starting with "."
+    ChunkMarkers      bool     `json:"chunk_markers"` // mark the start and end of each output file of a size split
+    WarnOnLeadingComment bool  `json:"warn_on_leading_comment"`
+
+    // Compiled from ExcludeRegex, HeaderTemplate and ContentMatch by loadConfig
+    excludePatterns []*regexp.Regexp
+    headerTemplate  *template.Template
+    contentPattern  *regexp.Regexp
+
+    // Absolute paths left out of discovery and merging, set by run
+    skipPaths []string
+    // Subfolders picked with --pick-subdir, mapped to their project
+    subdirProjects map[string]string
+    // Commit to merge instead of the working tree, set by run from --git-ref
+    gitRef string
+    // Ref whose changes limit the merge, set by run from --changed-since
+    changedSince string
+    // Lowercased keys set in the config file, set by readConfigFile
+    setKeys map[string]bool
+}
+
+// Section collects the files matching any of its patterns into an output
+// file named after it. Patterns work like include_patterns.
+type Section struct {
+    Name     string   `json:"name"`
+    Patterns []string `json:"patterns"`
+}
+
+// headerFields are the values available to header_template
+type headerFields struct {
+    Path string
+    Size int
+    Lang string
+    Part string // " (part 2/3)" for pieces of a split file, otherwise empty
+}
+
+const MB = 1024 * 1024
+
+// version is set at build time with -ldflags "-X main.version=v1.2.3"
+var version = "dev"
+
+// errBudgetExhausted stops the merge once max_total_size_mb is reached
+var errBudgetExhausted = errors.New("total size budget exhausted")
+
+// Exit codes for scripting
+const (
+    exitProcessingError = 1
+    exitConfigError     = 2
+    exitNoProjects      = 3
+    exitInterrupted     = 130
+)
+
+// errInterrupted is returned when Ctrl-C stops a merge
+var errInterrupted = &exitError{exitInterrupted, errors.New("interrupted")}
+
+// exitError attaches a process exit code to an error returned by run
+type exitError struct {
+    code int
+    err  error
+}
+
+func (e *exitError) Error() string { return e.err.Error() }
+func (e *exitError) Unwrap() error { return e.err }
+
+func main() {
+    if err := run(); err != nil {
+        errorf("%v", err)
+
+        code := exitProcessingError
+        var exitErr *exitError
+        if errors.As(err, &exitErr) {
+            code = exitErr.code
+        }
+        os.Exit(code)
+    }
+}
+
+// layeredFlag is a string flag that may be repeated, joining the values
+// with commas
+type layeredFlag struct {
+    value string
+    set   bool
+}
+
+func (f *layeredFlag) String() string {
+    if f == nil {
+        return ""
+    }
+    return f.value
+}
+
+func (f *layeredFlag) Set(value string) error {
+    if f.set {
+        f.value += "," + value
+    } else {
+        f.value, f.set = value, true
+    }
+    return nil
+}
+
+func run() error {
+    // Define a flag for the config file path
+    configFlag := &layeredFlag{value: "config.json"}
+    flag.Var(configFlag, "config", "Path to the configuration `file`, or - to read it from stdin (default $FILEMERGE_CONFIG). Repeat it or list paths separated by commas to layer configs.")
+    configPath := &configFlag.value
+    dryRun := flag.Bool("dry-run", false, "Report what would be merged without writing any output")
+    showTokens := flag.Bool("tokens", false, "Print estimated token counts for each output file")
+    writeManifestFile := flag.Bool("manifest", false, "Write a manifest.json index of the merged files")
+    projectFlag := flag.String("project", "", "Project name or path to merge, skipping fzf (comma-separated for several)")
+    watch := flag.Bool("watch", false, "Re-merge whenever a merged file changes")
+    watchInterval := flag.Duration("watch-interval", time.Second, "How often --watch checks the projects for changes")
+    toStdout := flag.Bool("stdout", false, "Write the merged content to stdout instead of output files")
+    clipboard := flag.Bool("clipboard", false, "Copy the merged output to the system clipboard")
+    verbose := flag.Bool("verbose", false, "Log every file merged or skipped, with the reason")
+    quiet := flag.Bool("quiet", false, "Only print errors")
+    assumeYes := flag.Bool("yes", false, "Clean the output folder without asking, even if it holds other files")
+    initConfig := flag.Bool("init", false, "Write a default config file to the --config path and exit")
+    force := flag.Bool("force", false, "Let --init overwrite an existing config file")
+    pickSubdir := flag.Bool("pick-subdir", false, "After picking projects, pick subfolders of them to merge instead")
+    noFzf := flag.Bool("no-fzf", false, "Pick projects from a numbered menu instead of fzf")
+    fromStdin := flag.Bool("from-stdin", false, "Read the candidate project paths from stdin, one per line, instead of discovering them")
+    checksum := flag.Bool("checksum", false, "Print a SHA-256 over all output files after merging")
+    openWhenDone := flag.Bool("open", false, "Open the output in $EDITOR (or the editor setting) or the default file manager when done")
+    listProjects := flag.Bool("list", false, "Print the discovered projects, one per line, and exit")
+    contentMatch := flag.String("content-match", "", "Only merge files whose content matches this regular expression (overrides content_match)")
+    jsonOutput := flag.Bool("json", false, "Write a single output.json array of {path, content} objects")
+    strict := flag.Bool("strict", false, "Abort on the first file that can't be read instead of skipping it")
+    archiveFormat := flag.String("archive", "", "Write the output files into a single archive: zip or targz")
+    incremental := flag.Bool("incremental", false, "Only rewrite output files whose source files changed since the last run")
+    gitRef := flag.String("git-ref", "", "Merge the files of a commit or branch instead of the working tree")
+    changedSince := flag.String("changed-since", "", "Only merge files changed since this commit or branch, according to git diff")
+    includeHidden := flag.Bool("include-hidden", false, "Merge files and folders starting with \".\" despite skip_hidden")
+    statsOnly := flag.Bool("stats-only", false, "Print the file count and size per extension of the selected projects instead of merging")
+    showVersion := flag.Bool("version", false, "Print version and build information and exit")
+    top := flag.Int("top", 0, "List the N largest merged files after the summary")
+    cpuProfile := flag.String("cpuprofile", "", "Write a CPU profile to this file")
+    memProfile := flag.String("memprofile", "", "Write a heap profile to this file on exit")
+    flag.Usage = printUsage
+    flag.Parse()
+
+    if *showVersion {
+        printVersion()
+        return nil
+    }
+
+    // Profiles for performance work, left out of --help
+    if *cpuProfile != "" {
+        profileFile, err := os.Create(*cpuProfile)
+        if err != nil {
+            return &exitError{exitConfigError, fmt.Errorf("creating CPU profile: %w", err)}
+        }
+        defer profileFile.Close()
+        if err := pprof.StartCPUProfile(profileFile); err != nil {
+            return &exitError{exitConfigError, fmt.Errorf("starting CPU profile: %w", err)}
+        }
+        defer pprof.StopCPUProfile()
+    }
+    if *memProfile != "" {
+        defer func() {
+            profileFile, err := os.Create(*memProfile)
+            if err != nil {
+                errorf("creating heap profile: %v", err)
+                return
+            }
+            defer profileFile.Close()
+            runtime.GC()
+            if err := pprof.WriteHeapProfile(profileFile); err != nil {
+                errorf("writing heap profile: %v", err)
+            }
+        }()
+    }
+
+    switch {
+    case *verbose && *quiet:
+        return &exitError{exitConfigError, errors.New("--verbose and --quiet cannot be combined")}
+    case *verbose:
+        currentLogLevel = levelDebug
+    case *quiet:
+        currentLogLevel = levelError
+    }
+
+    if *toStdout || *listProjects {
+        logOutput = os.Stderr
+    }
+    if *toStdout {
+        if *writeManifestFile || *clipboard {
+            return &exitError{exitConfigError, errors.New("--manifest and --clipboard cannot be combined with --stdout")}
+        }
+    }
+
+    if *archiveFormat != "" {
+        if _, ok := archiveFileNames[*archiveFormat]; !ok {
+            return &exitError{exitConfigError, fmt.Errorf("unknown --archive format %q (expected zip or targz)", *archiveFormat)}
+        }
+        if *toStdout || *clipboard || *incremental {
+            return &exitError{exitConfigError, errors.New("--archive cannot be combined with --stdout, --clipboard or --incremental")}
+        }
+    }
+
+    if *fromStdin && (*configPath == "-" || isTerminal(os.Stdin)) {
+        return &exitError{exitConfigError, errors.New("--from-stdin needs the project list piped in, and can't be combined with --config -")}
+    }
+
+    if *checksum && (*toStdout || *archiveFormat != "" || *dryRun) {
+        return &exitError{exitConfigError, errors.New("--checksum needs output files, it cannot be combined with --stdout, --archive or --dry-run")}
+    }
+
+    // The cache describes output files, which these runs don't write
+    if *incremental && (*toStdout || *dryRun) {
+        return &exitError{exitConfigError, errors.New("--incremental cannot be combined with --stdout or --dry-run")}
+    }
+
+    if *watchInterval <= 0 {
+        return &exitError{exitConfigError, errors.New("--watch-interval must be greater than 0")}
+    }
+
+    if *gitRef != "" && *watch {
+        return &exitError{exitConfigError, errors.New("--git-ref cannot be combined with --watch")}
+    }
+
+    // Fall back to $FILEMERGE_CONFIG when --config isn't given
+    configSet := false
+    flag.Visit(func(f *flag.Flag) {
+        if f.Name == "config" {
+            configSet = true
+        }
+    })
+    if envConfig := os.Getenv("FILEMERGE_CONFIG"); !configSet && envConfig != "" {
+        *configPath = envConfig
+    }
+
+    if *initConfig {
+        if err := writeDefaultConfig(*configPath, *force); err != nil {
+            return &exitError{exitConfigError, err}
+        }
+        infof("Wrote %s, edit root_folder and output_folder before the first run.", *configPath)
+        return nil
+    }
+
+    // Without a config.json, a commented config.jsonc is used instead
+    if !configSet && *configPath == "config.json" {
+        if _, err := os.Stat("config.json"); os.IsNotExist(err) {
+            if _, err := os.Stat("config.jsonc"); err == nil {
+                *configPath = "config.jsonc"
+            }
+        }
+    }
+
+    // Get the absolute paths of the config files, which may be a
+    // comma-separated list of layers. A config read from stdin ("-")
+    // resolves its paths against the working directory.
+    configPaths := strings.Split(*configPath, ",")
+    var err error
+    for i, path := range configPaths {
+        if path == "-" {
+            continue
+        }
+        configPaths[i], err = filepath.Abs(path)
+        if err != nil {
+            return &exitError{exitConfigError, fmt.Errorf("getting absolute path of config file: %w", err)}
+        }
+    }
+    configDir, err := configDirOf(configPaths[0])
+    if err != nil {
+        return &exitError{exitConfigError, err}
+    }
+
+    config, err := prepareConfig(configPaths, configDir, *contentMatch)
+    if err != nil {
+        return err
+    }
+    // Markers are added once the number of output files is known, which
+    // incremental and archived output files are written before
+    if config.ChunkMarkers && (*incremental || *archiveFormat != "") {
+        return &exitError{exitConfigError, errors.New("chunk_markers cannot be combined with --incremental or --archive")}
+    }
+    absConfigPath := strings.Join(configPaths, ", ")
+    if *configPath == "-" {
+        absConfigPath = "(stdin)"
+    }
+
+    // Keep the console clear of warnings when they go to a log file
+    if config.WarningsLog != "" {
+        logFile, err := os.OpenFile(config.WarningsLog, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+        if err != nil {
+            return &exitError{exitConfigError, fmt.Errorf("warnings_log: %w", err)}
+        }
+        defer logFile.Close()
+        warningsLog = logFile
+        defer func() {
+            if warningCount > 0 {
+                infof("%d warning(s) written to %s", warningCount, config.WarningsLog)
+            }
+        }()
+    }
+    outputFolder := config.OutputFolder
+    rootFolders := allRootFolders(config)
+    config.gitRef = *gitRef
+    config.changedSince = *changedSince
+    if *includeHidden {
+        config.SkipHidden = false
+    }
+
+    infof("Config file: %s", absConfigPath)
+    infof("Output folder: %s", outputFolder)
+    for _, rootFolder := range rootFolders {
+        infof("Root folder: %s", rootFolder)
+    }
+    if config.gitRef != "" {
+        infof("Git ref: %s", config.gitRef)
+    }
+    if config.changedSince != "" {
+        infof("Changed since: %s", config.changedSince)
+    }
+
+    // Find projects (directories containing a marker file) up to the scan
+    // depth, in every root folder. Overlapping roots list a project once.
+    // With --from-stdin, the piped list replaces discovery.
+    // Every read from stdin shares one reader, so input buffered by one
+    // prompt isn't lost to the next
+    stdin := bufio.NewReader(os.Stdin)
+    var projects []string
+    if *fromStdin {
+        projects, err = readProjectList(stdin)
+        if err != nil {
+            return &exitError{exitConfigError, fmt.Errorf("reading projects from stdin: %w", err)}
+        }
+    } else {
+        seenProjects := map[string]bool{}
+        for _, rootFolder := range rootFolders {
+            rootProjects, err := findProjects(rootFolder, config)
+            if err != nil {
+                return fmt.Errorf("scanning projects: %w", err)
+            }
+            for _, project := range rootProjects {
+                if !seenProjects[project] {
+                    seenProjects[project] = true
+                    projects = append(projects, project)
+                }
+            }
+        }
+    }
+
+    if *listProjects {
+        for _, project := range projects {
+            fmt.Println(project)
+        }
+        return nil
+    }
+
+    if len(projects) == 0 {
+        return &exitError{exitNoProjects, errors.New("no projects found")}
+    }
+
+    // Interactive picking uses fzf, or a numbered menu when it isn't available
+    useMenu := *noFzf
+    pick := func(paths []string) ([]string, error) {
+        if _, lookErr := exec.LookPath("fzf"); !useMenu && lookErr != nil {
+            warnf("fzf not found, install it from https://github.com/junegunn/fzf or pass --project. Using a numbered menu instead.")
+            useMenu = true
+        }
+        if useMenu {
+            return selectProjectsFromMenu(stdin, paths)
+        }
+        return selectProjectsWithFzf(paths, config.FzfArgs)
+    }
+
+    // Use the projects given on the command line, or let the user select
+    // one or more projects using fzf
+    var selectedProjects []string
+    if *projectFlag != "" {
+        for _, name := range strings.Split(*projectFlag, ",") {
+            project, ok := findProject(projects, strings.TrimSpace(name))
+            if !ok {
+                message := fmt.Sprintf("no project matches %q. Available projects:", name)
+                for _, project := range projects {
+                    message += "\n  " + project
+                }
+                return &exitError{exitNoProjects, errors.New(message)}
+            }
+            selectedProjects = append(selectedProjects, project)
+        }
+    } else {
+        selectedProjects, err = pick(projects)
+        if err != nil {
+            return fmt.Errorf("selecting project: %w", err)
+        }
+    }
+
+    if len(selectedProjects) == 0 {
+        return errors.New("no project selected")
+    }
+
+    // Optionally narrow projects down to some of their subfolders, which
+    // then serve as the root for header paths
+    if *pickSubdir {
+        var narrowed []string
+        config.subdirProjects = map[string]string{}
+        for _, project := range selectedProjects {
+            subdirs, err := listSubdirs(project, config)
+            if err != nil {
+                return fmt.Errorf("listing subfolders: %w", err)
+            }
+
+            var picked []string
+            if len(subdirs) > 0 {
+                infof("Pick subfolders of %s:", project)
+                picked, err = pick(subdirs)
+                if err != nil {
+                    return fmt.Errorf("selecting subfolder: %w", err)
+                }
+            }
+            if len(picked) == 0 {
+                narrowed = append(narrowed, project)
+                continue
+            }
+            for _, subdir := range picked {
+                config.subdirProjects[subdir] = project
+                narrowed = append(narrowed, subdir)
+            }
+        }
+        selectedProjects = narrowed
+    }
+
+    for _, selectedProject := range selectedProjects {
+        infof("Selected project: %s", colorize(ansiBold, selectedProject))
+        name, scripts, err := readPackageMeta(selectedProject)
+        if err != nil {
+            warnf("reading package.json of %s: %v", selectedProject, err)
+        }
+        if name != "" {
+            infof("  Package: %s", name)
+        }
+        if len(scripts) > 0 {
+            infof("  Scripts: %s", strings.Join(scripts, ", "))
+        }
+        // Projects from --from-stdin may lie outside the root folders, which
+        // prepareConfig already checked
+        if isSubpath(selectedProject, outputFolder) {
+            warnf("output folder %s is inside %s, it is left out of the merge", outputFolder, selectedProject)
+            if !isSkippedPath(outputFolder, config.skipPaths) {
+                config.skipPaths = append(config.skipPaths, filepath.Clean(outputFolder))
+            }
+        }
+    }
+
+    // Report what the selection is made of, without touching the output
+    if *statsOnly {
+        var files []sourceFile
+        var stats mergeStats
+        for _, selectedProject := range selectedProjects {
+            projectFiles, err := collectProjectFiles(context.Background(), selectedProject, config, &stats)
+            if err != nil {
+                return fmt.Errorf("scanning project: %w", err)
+            }
+            files = append(files, projectFiles...)
+        }
+        printExtensionStats(files)
+        return nil
+    }
+
+    // Make sure cleaning the output folder can't destroy anything important
+    if !*dryRun && !*toStdout {
+        homeDir, _ := os.UserHomeDir()
+        err = checkOutputFolderSafe(outputFolder, append([]string{configDir, homeDir}, rootFolders...))
+        if err != nil {
+            return &exitError{exitConfigError, err}
+        }
+
+        // Other files are only at risk when the whole folder is wiped
+        foreign := false
+        if !config.CleanOnlyGenerated {
+            var mirrored map[string]bool
+            if config.SplitStrategy == "mirror" {
+                mirrored, err = loadMirrorList(outputFolder)
+                if err != nil {
+                    return err
+                }
+            }
+            foreign, err = hasForeignFiles(outputFolder, config.OutputFilePattern, splitsByGroup(config), mirrored)
+            if err != nil {
+                return err
+            }
+        }
+        if foreign && !*assumeYes {
+            if !isTerminal(os.Stdin) {
+                return &exitError{exitConfigError, fmt.Errorf("output folder %s contains files not created by this tool; rerun with --yes to remove them", outputFolder)}
+            }
+            if !confirm(stdin, fmt.Sprintf("Output folder %s contains files not created by this tool. Remove everything in it?", outputFolder)) {
+                return errors.New("aborted, output folder left untouched")
+            }
+        }
+    }
+
+    options := mergeOptions{
+        dryRun:      *dryRun,
+        showTokens:  *showTokens,
+        manifest:    *writeManifestFile,
+        stdout:      *toStdout,
+        clipboard:   *clipboard,
+        incremental: *incremental,
+        archive:     *archiveFormat,
+        strict:      *strict,
+        json:        *jsonOutput,
+        open:        *openWhenDone && !*dryRun && !*toStdout,
+        checksum:    *checksum,
+        top:         *top,
+    }
+
+    // Ctrl-C stops the merge cleanly, a second one exits right away
+    ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+    defer stop()
+    go func() {
+        <-ctx.Done()
+        stop()
+    }()
+
+    err = mergeProjects(ctx, selectedProjects, config, outputFolder, options)
+    if !*watch || ctx.Err() != nil {
+        return err
+    }
+    if err != nil {
+        errorf("%v", err)
+    }
+
+    // Keep the output fresh while the selected projects or the config change.
+    // The output folder was checked above and can't move while watching.
+    var watchedConfigs []string
+    for _, path := range configPaths {
+        if path != "-" {
+            watchedConfigs = append(watchedConfigs, path)
+        }
+    }
+    reload := func() (Config, error) {
+        newConfig, err := prepareConfig(configPaths, configDir, *contentMatch)
+        newConfig.subdirProjects = config.subdirProjects
+        newConfig.skipPaths = config.skipPaths
+        newConfig.changedSince = config.changedSince
+        if *includeHidden {
+            newConfig.SkipHidden = false
+        }
+        if err == nil && newConfig.OutputFolder != outputFolder {
+            err = fmt.Errorf("output_folder can't change while watching, restart to use %s", newConfig.OutputFolder)
+        }
+        return newConfig, err
+    }
+
+    // The output is opened once, not after every re-merge
+    options.open = false
+    infof("Watching for changes (press Ctrl-C to stop)...")
+    err = watchProjects(ctx, selectedProjects, config, watchedConfigs, *watchInterval, reload, func(config Config) error {
+        return mergeProjects(ctx, selectedProjects, config, outputFolder, options)
+    })
+    if err != nil {
+        return fmt.Errorf("watching projects: %w", err)
+    }
+    return nil
+}
+
+type mergeOptions struct {
+    dryRun      bool
+    showTokens  bool
+    manifest    bool
+    stdout      bool
+    clipboard   bool
+    incremental bool
+    archive     string // archive format, empty for loose output files
+    strict      bool   // abort on the first unreadable file
+    json        bool   // write a single JSON array instead of text output files
+    open        bool   // open the output when done
+    checksum    bool   // print a SHA-256 over all output files
+    top         int    // list this many of the largest merged files
+}
+
+// mergeProjects writes the merged output for the selected projects
+func mergeProjects(ctx context.Context, selectedProjects []string, config Config, outputFolder string, options mergeOptions) error {
+    // An incremental run only rewrites output files whose sources changed
+    var previousCache mergeCache
+    incremental := false
+    if options.incremental && !options.dryRun && !options.stdout {
+        var err error
+        previousCache, incremental, err = loadCache(outputFolder)
+        if err != nil {
+            warnf("ignoring unreadable %s: %v", cacheFileName, err)
+        }
+        // Different settings change every output file, so start over
+        if incremental && previousCache.Settings != settingsHash(config) {
+            previousCache, incremental = mergeCache{}, false
+        }
+    }
+    currentCache := mergeCache{Settings: settingsHash(config), Outputs: map[string][]cachedSource{}}
+    outputsRewritten := 0
+
+    // Collect the files to merge from every selected project
+    var stats mergeStats
+    var files []sourceFile
+    for _, selectedProject := range selectedProjects {
+        projectFiles, err := collectProjectFiles(ctx, selectedProject, config, &stats)
+        if ctx.Err() != nil {
+            return errInterrupted
+        }
+        if err != nil {
+            return fmt.Errorf("scanning project: %w", err)
+        }
+
+        if len(config.Sections) > 0 {
+            projectFiles = assignSections(projectFiles, config, &stats)
+        }
+
+        if len(selectedProjects) > 1 {
+            // Keep paths from different projects apart. Sections span projects.
+            for i := range projectFiles {
+                projectFiles[i].relPath = filepath.Join(filepath.Base(selectedProject), projectFiles[i].relPath)
+                if len(config.Sections) == 0 {
+                    projectFiles[i].group = filepath.Base(selectedProject) + "-" + projectFiles[i].group
+                }
+            }
+        }
+        files = append(files, projectFiles...)
+    }
+
+    // With the mirror strategy every file keeps its path below the output folder
+    switch config.SplitStrategy {
+    case "per-file":
+        assignFileGroups(files)
+    case "mirror":
+        for i := range files {
+            files[i].group = filepath.ToSlash(files[i].relPath)
+        }
+    }
+
+    for _, skipped := range stats.skippedTooLarge {
+        warnf("skipping large file %s", skipped)
+    }
+    for _, cycle := range stats.skippedCycles {
+        warnf("not following %s, it links back to one of its parent folders", cycle)
+    }
+
+    // Clean output directory (left untouched on a dry run, when writing to
+    // stdout, or when a previous incremental run can be reused). This waits
+    // for the scan, so interrupting it leaves the previous output intact.
+    if !options.dryRun && !options.stdout && !incremental {
+        err := cleanOutputDirectory(outputFolder, config)
+        if err != nil {
+            return fmt.Errorf("cleaning output directory: %w", err)
+        }
+    }
+
+    sortFiles(files, config.SortBy)
+
+    // Keep each top-level folder together so it ends up in a single output
+    // file. Sections keep the order they are configured in.
+    if config.SplitStrategy == "directory" {
+        sort.SliceStable(files, func(i, j int) bool {
+            return files[i].group < files[j].group
+        })
+    }
+    if len(config.Sections) > 0 {
+        order := map[string]int{}
+        for i, section := range config.Sections {
+            order[section.Name] = i
+        }
+        rank := func(group string) int {
+            if i, ok := order[group]; ok {
+                return i
+            }
+            return len(config.Sections)
+        }
+        sort.SliceStable(files, func(i, j int) bool {
+            return rank(files[i].group) < rank(files[j].group)
+        })
+    }
+
+    var tree string
+    if config.IncludeTree && !options.json && len(files) > 0 {
+        relPaths := make([]string, len(files))
+        for i, file := range files {
+            relPaths[i] = file.relPath
+        }
+        tree = renderTree(relPaths)
+    }
+
+    // Process the collected files, reading them concurrently but writing in walk order
+    outputFileIndex := 1
+    currentFileSize := 0
+    currentFileLines := 0
+    currentGroup := ""
+    var outputTokens []int
+    var outputFile *os.File
+    var outputWriter *bufio.Writer
+    var outputLines *lineCounter
+    var outputPaths []string
+    var outputNames []string
+    var pending *bytes.Buffer // incremental output, held until its sources are known
+    var pendingSources []cachedSource
+    var manifest []ManifestEntry
+    firstPathByHash := map[string]string{}
+
+    // Archived output is added to the archive one output file at a time
+    var archive outputArchive
+    if options.archive != "" && !options.dryRun && !options.stdout {
+        var err error
+        archive, err = createArchive(outputFolder, options.archive)
+        if err != nil {
+            return fmt.Errorf("creating archive: %w", err)
+        }
+    }
+
+    finishOutput := func() error {
+        if outputWriter == nil {
+            return nil
+        }
+        if options.json {
+            io.WriteString(outputLines, "\n]\n")
+        }
+        err := outputWriter.Flush()
+        if outputFile != nil {
+            outputFile.Close()
+        }
+        if err != nil || pending == nil {
+            return err
+        }
+
+        name := outputNames[len(outputNames)-1]
+        if archive != nil {
+            return archive.add(name, pending.Bytes())
+        }
+
+        currentCache.Outputs[name] = pendingSources
+        if previousCache.upToDate(outputFolder, name, pendingSources) {
+            debugf("Kept unchanged %s", name)
+            return nil
+        }
+        outputsRewritten++
+        outputPath := filepath.Join(outputFolder, name)
+        if err := os.MkdirAll(filepath.Dir(outputPath), os.ModePerm); err != nil {
+            return err
+        }
+        return os.WriteFile(outputPath, pending.Bytes(), 0666)
+    }
+
+    // writeOmittedNotes notes the files max_files_per_dir left out of the
+    // folders of group, or of every group when it's empty. Folders none of
+    // whose files were merged get no note. Outputs holding a single source
+    // file stay a copy of it, so the notes are only logged for those.
+    mergedDirs := map[string]bool{}
+    writeOmittedNotes := func(group string) {
+        var notes string
+        for _, file := range files {
+            dir := filepath.Dir(file.relPath)
+            if file.omitted == 0 || !mergedDirs[dir] || (group != "" && file.group != group) {
+                continue
+            }
+            if config.SplitStrategy == "per-file" || config.SplitStrategy == "mirror" {
+                infof("%d more files in %s omitted", file.omitted, filepath.ToSlash(dir))
+                continue
+            }
+            notes += omittedNote(file, config)
+        }
+        if notes == "" {
+            return
+        }
+
+        if options.dryRun {
+            infof("  (omitted files) (%d bytes)", len(notes))
+        } else {
+            io.WriteString(outputLines, notes)
+        }
+        if pending != nil {
+            notesHash := sha256.Sum256([]byte(notes))
+            pendingSources = append(pendingSources, cachedSource{Path: "(omitted)", SHA256: hex.EncodeToString(notesHash[:])})
+        }
+        currentFileSize += len(notes)
+        stats.bytesWritten += len(notes)
+        outputTokens[len(outputTokens)-1] += estimateTokens([]byte(notes))
+    }
+
+    // writeEntry adds one source file, or one part of a split file, to the
+    // output, starting a new output file when needed
+    writeEntry := func(file sourceFile, content []byte, part string, sourceHash string, sourceSize int) error {
+        // Ensure output file exists and doesn't exceed the max size,
+        // or the token budget when one is configured. In directory mode
+        // every top-level folder gets its own file instead. Stdout is never split.
+        tokens := estimateTokens(content)
+        exceedsLimit := currentFileSize+len(content) > config.MaxFileSizeMB*MB
+        if config.MaxTokensPerFile > 0 && len(outputTokens) > 0 {
+            exceedsLimit = outputTokens[len(outputTokens)-1]+tokens > config.MaxTokensPerFile
+        }
+        lines := entryLineCount(content, config)
+        if config.MaxLinesPerFile > 0 {
+            if lines > config.MaxLinesPerFile {
+                warnf("%s has about %d lines, more than max_lines_per_file allows", file.relPath, lines)
+            }
+            exceedsLimit = exceedsLimit || (currentFileLines > 0 && currentFileLines+lines > config.MaxLinesPerFile)
+        }
+        outputName := outputFileName(outputFileIndex, config.OutputFilePattern)
+        if splitsByGroup(config) {
+            exceedsLimit = file.group != currentGroup
+            outputName = directoryOutputFileName(file.group, config.OutputFilePattern)
+        }
+        if config.SplitStrategy == "mirror" {
+            outputName = file.group
+        }
+        if options.json {
+            // The JSON array is never split
+            exceedsLimit = false
+            outputName = jsonOutputFileName
+        }
+        if outputFileIndex == 1 || (exceedsLimit && !options.stdout) {
+            // The output of the previous group closes with its notes
+            if splitsByGroup(config) && len(outputNames) > 0 && !options.json {
+                writeOmittedNotes(currentGroup)
+            }
+
+            // Guard against a split size far too small for the project
+            if config.MaxOutputFiles > 0 && len(outputNames) >= config.MaxOutputFiles {
+                return fmt.Errorf("more than max_output_files (%d) output files needed, the size or token limit is too small for this project", config.MaxOutputFiles)
+            }
+            if options.dryRun {
+                infof("%s:", outputName)
+            } else if options.stdout {
+                outputWriter = bufio.NewWriter(os.Stdout)
+                outputLines = &lineCounter{w: outputWriter}
+            } else if options.incremental || archive != nil {
+                if err := finishOutput(); err != nil {
+                    return err
+                }
+                pending = &bytes.Buffer{}
+                pendingSources = nil
+                if archive == nil {
+                    outputPaths = append(outputPaths, filepath.Join(outputFolder, outputName))
+                }
+                outputWriter = bufio.NewWriter(pending)
+                outputLines = &lineCounter{w: outputWriter}
+            } else {
+                if err := finishOutput(); err != nil {
+                    return err
+                }
+                var err error
+                outputFile, err = createNewOutputFile(outputFolder, outputName)
+                if err != nil {
+                    return err
+                }
+                outputPaths = append(outputPaths, outputFile.Name())
+                outputWriter = bufio.NewWriter(outputFile)
+                outputLines = &lineCounter{w: outputWriter}
+            }
+            outputFileIndex++
+            currentFileSize = 0
+            currentFileLines = 0
+            currentGroup = file.group
+            outputTokens = append(outputTokens, 0)
+            outputNames = append(outputNames, outputName)
+            if options.json && !options.dryRun {
+                io.WriteString(outputLines, "[")
+            }
+
+            // The first output file opens with the preamble and the project tree
+            if len(outputTokens) == 1 && config.Preamble != "" && !options.json {
+                preamble := config.Preamble
+                if !strings.HasSuffix(preamble, "\n") {
+                    preamble += "\n"
+                }
+                preamble += "\n"
+                if !options.dryRun {
+                    io.WriteString(outputLines, preamble)
+                }
+                currentFileSize += len(preamble)
+                currentFileLines += strings.Count(preamble, "\n")
+            }
+            if len(outputTokens) == 1 && tree != "" {
+                if !options.dryRun {
+                    writeTree(outputLines, tree, config.OutputFormat)
+                }
+                if pending != nil {
+                    treeHash := sha256.Sum256([]byte(tree))
+                    pendingSources = append(pendingSources, cachedSource{Path: "(tree)", SHA256: hex.EncodeToString(treeHash[:])})
+                }
+                currentFileSize += len(tree)
+                currentFileLines += strings.Count(tree, "\n") + 1
+            }
+        }
+
+        // Write file path as a comment and append the content
+        if options.dryRun {
+            infof("  %s%s (%d bytes)", file.relPath, part, len(content))
+        } else {
+            if options.json {
+                if stats.filesMerged > 0 {
+                    io.WriteString(outputLines, ",")
+                }
+                io.WriteString(outputLines, "\n")
+            }
+            startLine := outputLines.lines + 1
+            if options.json {
+                if err := writeFileAsJSON(outputLines, headerPath(file, config.HeaderPathStyle), content, config); err != nil {
+                    return err
+                }
+            } else {
+                writeFileWithComment(outputLines, headerPath(file, config.HeaderPathStyle), part, file.info, content, config)
+            }
+            debugf("Merged %s%s (%d bytes)", filepath.ToSlash(file.relPath), part, len(content))
+
+            if pending != nil {
+                source := cachedSource{Path: filepath.ToSlash(file.relPath), SHA256: sourceHash}
+                if config.HeaderMetadata {
+                    source.ModTime = file.info.ModTime().Unix()
+                }
+                pendingSources = append(pendingSources, source)
+            }
+
+            if options.manifest {
+                manifest = append(manifest, ManifestEntry{
+                    Path:       filepath.ToSlash(file.relPath),
+                    Size:       sourceSize,
+                    SHA256:     sourceHash,
+                    OutputFile: outputNames[len(outputNames)-1],
+                    StartLine:  startLine,
+                    EndLine:    outputLines.lastText,
+                })
+            }
+        }
+        currentFileSize += len(content)
+        currentFileLines += lines
+        stats.bytesWritten += len(content)
+        outputTokens[len(outputTokens)-1] += tokens
+        return nil
+    }
+
+    // Progress is only shown on a terminal and when nothing else is logged per file
+    progress := !options.dryRun && currentLogLevel == levelInfo && isTerminal(os.Stderr)
+    consumed := 0
+    var mergedPaths []string
+    var mergedSizes []int
+    err := readFilesInOrder(files, config.Workers, config.ReadRetries, func(file sourceFile, content []byte, err error) error {
+        // Stop between files, so every merged file is complete
+        if ctx.Err() != nil {
+            return ctx.Err()
+        }
+        if progress {
+            showProgress(consumed+1, len(files), filepath.ToSlash(file.relPath))
+        }
+        if err != nil {
+            if options.strict {
+                return err
+            }
+            stats.readErrors = append(stats.readErrors, err.Error())
+            return nil
+        }
+        consumed++
+
+        // Content settings may differ per project
+        fileConfig := &config
+        if file.config != nil {
+            fileConfig = file.config
+        }
+
+        // Skip binaries that slipped past the extension filter
+        if fileConfig.SkipBinaryFiles && detectBinary(content) {
+            stats.skippedBinary++
+            stats.logSkip(file.relPath, "binary")
+            return nil
+        }
+
+        // Normalize first, so every later step sees the final lines. The
+        // hash then treats files differing only in line endings as equal.
+        if fileConfig.NormalizeLineEndings {
+            content = normalizeLineEndings(content)
+        }
+
+        // Skip placeholder files with nothing but whitespace
+        if fileConfig.SkipEmptyFiles && len(bytes.TrimSpace(content)) == 0 {
+            stats.skippedEmpty++
+            stats.logSkip(file.relPath, "empty")
+            return nil
+        }
+
+        // Only files mentioning the content_match pattern are merged
+        if fileConfig.contentPattern != nil {
+            stats.contentExamined++
+            if !fileConfig.contentPattern.Match(content) {
+                stats.skippedContent++
+                stats.logSkip(file.relPath, "content doesn't match")
+                return nil
+            }
+        }
+
+        hash := sha256.Sum256(content)
+        sourceHash := hex.EncodeToString(hash[:])
+        sourceSize := len(content)
+
+        // Text in other encodings looks like text but would garble the output
+        if (fileConfig.SkipInvalidUTF8 || fileConfig.ReplaceInvalidUTF8) && !utf8.Valid(content) {
+            if !fileConfig.ReplaceInvalidUTF8 {
+                warnf("skipping %s, it isn't valid UTF-8", file.relPath)
+                stats.skippedInvalidUTF8++
+                return nil
+            }
+            warnf("replacing invalid UTF-8 in %s", file.relPath)
+            content = bytes.ToValidUTF8(content, []byte(string(utf8.RuneError)))
+        }
+
+        // Pipe the content through the transform for its extension
+        if command, ok := fileConfig.Transforms[strings.ToLower(filepath.Ext(file.relPath))]; ok {
+            transformed, err := runTransform(command, content)
+            if err != nil {
+                warnf("transform of %s failed, merging it unchanged: %v", file.relPath, err)
+            } else {
+                content = transformed
+            }
+        }
+
+        if fileConfig.TrimTrailingWhitespace {
+            content = trimTrailingWhitespace(content)
+        }
+        if fileConfig.CollapseBlankLines {
+            content = collapseBlankLines(content)
+        }
+
+        // Cut noisy file types such as minified bundles down to size
+        if limitKB, ok := fileConfig.PerExtensionMaxKB[strings.ToLower(filepath.Ext(file.relPath))]; ok && len(content) > limitKB*1024 {
+            debugf("Truncated %s to %d KB", filepath.ToSlash(file.relPath), limitKB)
+            content = truncateContent(content, limitKB*1024, file.relPath)
+        }
+
+        // Number lines before any split, so parts keep the original numbers
+        if fileConfig.LineNumbers && !options.json {
+            content = numberLines(content)
+        }
+
+        // Replace repeated content with a reference to its first occurrence
+        if config.DeduplicateContent {
+            if firstPath, ok := firstPathByHash[sourceHash]; ok {
+                prefix, suffix := commentPrefixFor(file.relPath)
+                reference := prefix + " duplicate of " + filepath.ToSlash(firstPath)
+                if suffix != "" {
+                    reference += " " + suffix
+                }
+                // A reference can be longer than a tiny duplicate
+                if saved := len(content) - len(reference) - 1; saved > 0 {
+                    stats.bytesDeduplicated += saved
+                }
+                content = []byte(reference + "\n")
+            } else {
+                firstPathByHash[sourceHash] = file.relPath
+            }
+        }
+
+        // Stop once the total size budget is used up, sort_by decides which
+        // files make it in
+        if config.MaxTotalSizeMB > 0 && stats.bytesWritten+len(content)+len(config.Footer) > config.MaxTotalSizeMB*MB {
+            stats.skippedBudget = len(files) - consumed + 1
+            return errBudgetExhausted
+        }
+
+        // A file too large for any output file on its own is split at line
+        // boundaries or skipped, so chunks stay predictable
+        parts := [][]byte{content}
+        limit, measure := config.MaxFileSizeMB*MB, func(b []byte) int { return len(b) }
+        if config.MaxTokensPerFile > 0 {
+            limit, measure = config.MaxTokensPerFile, estimateTokens
+        }
+        if !options.stdout && !options.json && !splitsByGroup(config) && measure(content) > limit {
+            if !config.SplitLargeFiles {
+                warnf("skipping %s, it doesn't fit in a single output file (set split_large_files to split it)", file.relPath)
+                stats.skippedOversized++
+                return nil
+            }
+            parts = splitAtLines(content, limit, measure)
+        }
+
+        for i, part := range parts {
+            label := ""
+            if len(parts) > 1 {
+                label = fmt.Sprintf(" (part %d/%d)", i+1, len(parts))
+            }
+            if err := writeEntry(file, part, label, sourceHash, sourceSize); err != nil {
+                return err
+            }
+        }
+        stats.filesMerged++
+        mergedDirs[filepath.Dir(file.relPath)] = true
+        mergedPaths = append(mergedPaths, file.relPath)
+        mergedSizes = append(mergedSizes, len(content))
+        return nil
+    })
+
+    // The last output file closes with the notes of its group, or of every
+    // group when outputs aren't split by group, then the footer
+    if (err == nil || err == errBudgetExhausted) && len(outputNames) > 0 && !options.json {
+        if splitsByGroup(config) && !options.stdout {
+            writeOmittedNotes(currentGroup)
+        } else {
+            writeOmittedNotes("")
+        }
+
+        if footer := buildFooter(config, mergedPaths); footer != "" {
+            if options.dryRun {
+                infof("  (footer) (%d bytes)", len(footer))
+            } else {
+                io.WriteString(outputLines, footer)
+            }
+            if pending != nil {
+                footerHash := sha256.Sum256([]byte(footer))
+                pendingSources = append(pendingSources, cachedSource{Path: "(footer)", SHA256: hex.EncodeToString(footerHash[:])})
+            }
+            stats.bytesWritten += len(footer)
+            outputTokens[len(outputTokens)-1] += estimateTokens([]byte(footer))
+        }
+    }
+
+    clearProgress()
+    if finishErr := finishOutput(); finishErr != nil && err == nil {
+        err = finishErr
+    }
+    if archive != nil {
+        if closeErr := archive.Close(); closeErr != nil && err == nil {
+            err = closeErr
+        }
+    }
+    // Record the mirrored files, even of an interrupted run, so the next
+    // run can clean them up
+    if config.SplitStrategy == "mirror" && !options.dryRun && !options.stdout && archive == nil && len(outputNames) > 0 {
+        if listErr := writeMirrorList(outputFolder, outputNames); listErr != nil && err == nil {
+            err = fmt.Errorf("writing %s: %w", mirrorListFileName, listErr)
+        }
+    }
+    if ctx.Err() != nil {
+        warnf("interrupted after merging %d of %d files into %d output file(s), the output is incomplete", stats.filesMerged, len(files), outputFileIndex-1)
+        return errInterrupted
+    }
+    if err == errBudgetExhausted {
+        warnf("reached max_total_size_mb of %d MB, skipped the remaining %d file(s)", config.MaxTotalSizeMB, stats.skippedBudget)
+        err = nil
+    }
+
+    if err != nil {
+        return fmt.Errorf("processing project: %w", err)
+    }
+    stats.outputFiles = outputFileIndex - 1
+
+    // Outputs split by group are named after their content and may be
+    // copies of source files, so only size-based chunks are marked. A dry
+    // run counts the markers like the real run does.
+    if config.ChunkMarkers && !splitsByGroup(config) && !options.stdout && len(outputNames) > 1 {
+        for i, name := range outputNames {
+            header, footer := chunkMarkers(i+1, len(outputNames), config.OutputFormat)
+            if options.dryRun {
+                infof("  (chunk markers of %s) (%d bytes)", name, len(header)+len(footer))
+            }
+            stats.bytesWritten += len(header) + len(footer)
+            outputTokens[i] += estimateTokens([]byte(header + footer))
+        }
+        if !options.dryRun {
+            if err := addChunkMarkers(outputPaths, config.OutputFormat); err != nil {
+                return fmt.Errorf("adding chunk markers: %w", err)
+            }
+            // Every output file now starts with the marker and a blank line
+            for i := range manifest {
+                manifest[i].StartLine += 2
+                manifest[i].EndLine += 2
+            }
+        }
+    }
+
+    if options.showTokens {
+        printTokenSummary(outputTokens, outputNames)
+    }
+
+    if options.dryRun {
+        infof("Dry run: %d bytes would be written to %d output file(s).", stats.bytesWritten, stats.outputFiles)
+        printSummary(stats)
+        printLargestFiles(mergedPaths, mergedSizes, options.top)
+        return nil
+    }
+
+    if options.manifest {
+        err = writeManifest(outputFolder, manifest)
+        if err != nil {
+            return fmt.Errorf("writing manifest: %w", err)
+        }
+    }
+
+    if options.incremental && !options.stdout {
+        // Drop output files a previous run produced that aren't part of this one
+        for name := range previousCache.Outputs {
+            if _, ok := currentCache.Outputs[name]; !ok {
+                os.Remove(filepath.Join(outputFolder, name))
+                outputsRewritten++
+            }
+        }
+        if err := writeCache(outputFolder, currentCache); err != nil {
+            return fmt.Errorf("writing %s: %w", cacheFileName, err)
+        }
+    }
+
+    if options.incremental && incremental && outputsRewritten == 0 {
+        infof("%s", colorize(ansiGreen, "No changes, output up to date."))
+    } else {
+        infof("%s", colorize(ansiGreen, "Merging complete."))
+    }
+    printSummary(stats)
+    printLargestFiles(mergedPaths, mergedSizes, options.top)
+
+    if options.clipboard {
+        var merged []byte
+        for _, outputPath := range outputPaths {
+            content, err := os.ReadFile(outputPath)
+            if err != nil {
+                return fmt.Errorf("reading output for clipboard: %w", err)
+            }
+            merged = append(merged, content...)
+        }
+
+        tool, err := copyToClipboard(merged)
+        if err != nil {
+            return fmt.Errorf("copying to clipboard: %w", err)
+        }
+        infof("Copied %d bytes to the clipboard using %s.", len(merged), tool)
+    }
+
+    if options.checksum {
+        sum, err := outputChecksum(outputPaths)
+        if err != nil {
+            return fmt.Errorf("computing checksum: %w", err)
+        }
+        fmt.Println(sum)
+    }
+
+    if options.open {
+        editor := strings.TrimSpace(config.Editor)
+        if editor == "" {
+            editor = strings.TrimSpace(os.Getenv("EDITOR"))
+        }
+        target := outputFolder
+        if editor != "" && len(outputPaths) > 0 {
+            target = outputPaths[0]
+        }
+        if err := openOutput(target, editor); err != nil {
+            return fmt.Errorf("opening output: %w", err)
+        }
+    }
+
+    if config.PostMergeCommand != "" {
+        if err := runPostMergeCommand(config.PostMergeCommand, outputFolder); err != nil {
+            return fmt.Errorf("post_merge_command: %w", err)
+        }
+    }
+    return nil
+}
+
+// chunkMarkers returns the lines that open and close output file index of
+// total, such as "// === chunk 2 of 5 ===", so chunks pasted one after
+// another read as a sequence
+func chunkMarkers(index int, total int, format string) (string, string) {
+    prefix, suffix := "//", ""
+    if format == "markdown" {
+        prefix, suffix = "<!--", " -->"
+    }
+    header := fmt.Sprintf("%s === chunk %d of %d ===%s\n\n", prefix, index, total, suffix)
+    footer := fmt.Sprintf("\n%s === end chunk %d ===%s\n", prefix, index, suffix)
+    return header, footer
+}
+
+// addChunkMarkers wraps each output file of a split in its chunk markers
+func addChunkMarkers(outputPaths []string, format string) error {
+    for i, outputPath := range outputPaths {
+        content, err := os.ReadFile(outputPath)
+        if err != nil {
+            return err
+        }
+        header, footer := chunkMarkers(i+1, len(outputPaths), format)
+        if err := os.WriteFile(outputPath, append(append([]byte(header), content...), footer...), 0666); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// runTransform pipes content through a transforms command and returns
+// what it prints
+func runTransform(command string, content []byte) ([]byte, error) {
+    cmd := shellCommand(command)
+    cmd.Stdin = bytes.NewReader(content)
+
+    var stderr bytes.Buffer
+    cmd.Stderr = &stderr
+    output, err := cmd.Output()
+    if err != nil {
+        if message := strings.TrimSpace(stderr.String()); message != "" {
+            return nil, fmt.Errorf("%w: %s", err, message)
+        }
+        return nil, err
+    }
+    return output, nil
+}
+
+// outputChecksum hashes the output files concatenated in sorted name
+// order. It only depends on their content.
+func outputChecksum(outputPaths []string) (string, error) {
+    sorted := append([]string(nil), outputPaths...)
+    sort.Strings(sorted)
+
+    hash := sha256.New()
+    for _, outputPath := range sorted {
+        file, err := os.Open(outputPath)
+        if err != nil {
+            return "", err
+        }
+        _, err = io.Copy(hash, file)
+        file.Close()
+        if err != nil {
+            return "", err
+        }
+    }
+    return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// openOutput opens target in the editor, or with the platform's default
+// handler when no editor is configured
+func openOutput(target string, editor string) error {
+    // An editor setting of only whitespace falls back to the default opener
+    args := strings.Fields(editor)
+    var cmd *exec.Cmd
+    switch {
+    case len(args) > 0:
+        cmd = exec.Command(args[0], append(args[1:], target)...)
+    case runtime.GOOS == "darwin":
+        cmd = exec.Command("open", target)
+    case runtime.GOOS == "windows":
+        cmd = exec.Command("explorer", target)
+    default:
+        cmd = exec.Command("xdg-open", target)
+    }
+
+    // Terminal editors need the terminal
+    cmd.Stdin = os.Stdin
+    cmd.Stdout = os.Stdout
+    cmd.Stderr = os.Stderr
+    return cmd.Run()
+}
+
+// shellCommand runs a configured command line through the system shell
+func shellCommand(command string) *exec.Cmd {
+    if runtime.GOOS == "windows" {
+        return exec.Command("cmd", "/C", command)
+    }
+    return exec.Command("sh", "-c", command)
+}
+
+// runPostMergeCommand runs the hook through the shell with the output
+// folder in $FILEMERGE_OUTPUT
+func runPostMergeCommand(command string, outputFolder string) error {
+    cmd := shellCommand(command)
+    cmd.Env = append(os.Environ(), "FILEMERGE_OUTPUT="+outputFolder)
+    cmd.Stdin = os.Stdin
+    cmd.Stdout = logOutput // stderr with --stdout, to keep the merged content clean
+    cmd.Stderr = os.Stderr
+    return cmd.Run()
+}
+
+type mergeStats struct {
+    filesMerged      int
+    bytesWritten     int
+    outputFiles      int
+    skippedBlacklist int // folders, including everything below them
+    skippedHidden    int
+    skippedGitignore int
+    skippedMergeignore int
+    skippedRoot      int
+    skippedExtension int
+    skippedNotAllowed int
+    skippedInclude   int
+    skippedBinary    int
+    skippedInvalidUTF8 int
+    skippedRegex     int
+    skippedTooLarge  []string
+    skippedCycles    []string
+    skippedBudget    int
+    skippedTooSmall  int
+    skippedOversized int
+    skippedEmpty     int
+    skippedUnchanged int
+    skippedPerDir    int
+    skippedUntracked int
+    skippedNoSection int
+    readErrors       []string
+    contentExamined  int
+    skippedContent   int
+    bytesDeduplicated int
+
+    // silent suppresses per-file logging for background scans
+    silent bool
+}
+
+// logSkip reports a skipped path and the reason at verbose level
+func (s *mergeStats) logSkip(relPath string, reason string) {
+    if !s.silent {
+        debugf("Skipped %s (%s)", filepath.ToSlash(relPath), reason)
+    }
+}
+
+func printSummary(stats mergeStats) {
+    skipped := []struct {
+        reason string
+        count  int
+    }{
+        {"blacklisted folders", stats.skippedBlacklist},
+        {"hidden", stats.skippedHidden},
+        {"ignored by .gitignore or respect_ignore_files", stats.skippedGitignore},
+        {"ignored by .filemergeignore", stats.skippedMergeignore},
+        {"in project root", stats.skippedRoot},
+        {"ignored extension", stats.skippedExtension},
+        {"extension not in allowed_file_types", stats.skippedNotAllowed},
+        {"not matching include patterns", stats.skippedInclude},
+        {"binary", stats.skippedBinary},
+        {"empty", stats.skippedEmpty},
+        {"invalid UTF-8", stats.skippedInvalidUTF8},
+        {"matching exclude_regex", stats.skippedRegex},
+        {"larger than max_source_file_kb", len(stats.skippedTooLarge)},
+        {"smaller than min_source_file_bytes", stats.skippedTooSmall},
+        {"unchanged since --changed-since", stats.skippedUnchanged},
+        {"not tracked by git", stats.skippedUntracked},
+        {"matching no section", stats.skippedNoSection},
+        {"over max_files_per_dir", stats.skippedPerDir},
+        {"too large for one output file", stats.skippedOversized},
+        {"over max_total_size_mb", stats.skippedBudget},
+        {"unreadable", len(stats.readErrors)},
+        {"content not matching content_match", stats.skippedContent},
+    }
+
+    total := 0
+    for _, s := range skipped {
+        total += s.count
+    }
+
+    infof("Summary:")
+    infof("  Files merged:  %d", stats.filesMerged)
+    infof("  Skipped:       %d", total)
+    for _, s := range skipped {
+        if s.count > 0 {
+            infof("    %s: %d", s.reason, s.count)
+        }
+    }
+    if stats.contentExamined > 0 {
+        infof("  Content matches: %d of %d examined", stats.contentExamined-stats.skippedContent, stats.contentExamined)
+    }
+    infof("  Bytes written: %d", stats.bytesWritten)
+    if stats.bytesDeduplicated > 0 {
+        infof("  Bytes saved by deduplication: %d", stats.bytesDeduplicated)
+    }
+    infof("  Output files:  %d", stats.outputFiles)
+
+    for _, readErr := range stats.readErrors {
+        warnf("skipped unreadable file, %s", readErr)
+    }
+}
+
+// prepareConfig loads a config and resolves its paths against configDir.
+// Every error it returns is a config error.
+func prepareConfig(configPaths []string, configDir string, contentMatch string) (Config, error) {
+    config, err := loadConfig(configPaths)
+    if err != nil {
+        return Config{}, &exitError{exitConfigError, fmt.Errorf("loading config: %w", err)}
+    }
+
+    if contentMatch != "" {
+        config.ContentMatch = contentMatch
+        config.contentPattern, err = regexp.Compile(contentMatch)
+        if err != nil {
+            return Config{}, &exitError{exitConfigError, fmt.Errorf("--content-match %q: %w", contentMatch, err)}
+        }
+    }
+
+    // Paths were resolved against their own config file by loadConfig.
+    // Unset folders default to the folder of the first config, except that
+    // root_folder isn't needed when root_folders is used. The output goes to
+    // a merged folder there, as the config folder itself is never cleaned.
+    if config.OutputFolder == "" {
+        config.OutputFolder = filepath.Join(configDir, "merged")
+    }
+    if config.RootFolder == "" && len(config.RootFolders) == 0 {
+        config.RootFolder = configDir
+    }
+
+    // An output folder below a root folder must never be merged back in
+    for _, rootFolder := range allRootFolders(config) {
+        if isSubpath(rootFolder, config.OutputFolder) {
+            config.skipPaths = append(config.skipPaths, config.OutputFolder)
+            break
+        }
+    }
+
+    // The preamble file is written after any inline preamble
+    if config.PreambleFile != "" {
+        content, err := os.ReadFile(config.PreambleFile)
+        if err != nil {
+            return Config{}, &exitError{exitConfigError, fmt.Errorf("preamble_file: %w", err)}
+        }
+        config.Preamble += string(content)
+    }
+
+    // Likewise for the footer
+    if config.FooterFile != "" {
+        content, err := os.ReadFile(config.FooterFile)
+        if err != nil {
+            return Config{}, &exitError{exitConfigError, fmt.Errorf("footer_file: %w", err)}
+        }
+        config.Footer += string(content)
+    }
+
+    // Report every config problem at once instead of failing on the first
+    if problems := validateConfig(config); len(problems) > 0 {
+        name := strings.Join(configPaths, ", ")
+        if name == "-" {
+            name = "(stdin)"
+        }
+        message := "invalid config " + name + ":"
+        for _, problem := range problems {
+            message += "\n  - " + problem.Error()
+        }
+        return Config{}, &exitError{exitConfigError, errors.New(message)}
+    }
+
+    return config, nil
+}
+
+// defaultConfig is written by --init. Paths are resolved relative to the
+// config file. Comments are allowed, see stripJSONComments.
+const defaultConfig = `{
+  // Where to look for projects, and where the merged output goes. The
+  // output folder is emptied on every run.
+  "root_folder": "~/projects",
+  // "root_folders": [],             // more folders to look for projects in
+  "output_folder": "./merged",
+  // "scan_depth": 1,                // folder levels below a root searched for projects
+
+  // A folder holding one of these files is a project (default ["package.json"])
+  "project_markers": ["package.json", "go.mod", "Cargo.toml", "pyproject.toml"],
+
+  // Output files are split once they reach this size
+  "max_file_size_mb": 5,
+  // "max_tokens_per_file": 0,       // split by estimated tokens instead, 0 is off
+  // "max_lines_per_file": 0,        // also split after this many lines, 0 is off
+  // "max_output_files": 0,          // fail instead of writing more output files, 0 is off
+  // "max_total_size_mb": 0,         // stop merging after this much output, 0 is off
+  // "split_strategy": "size",       // "size", "directory", "per-file" or "mirror"
+  // "split_large_files": false,     // split files larger than one output file instead of skipping them
+  // "chunk_markers": false,         // mark each output file of a size split as "chunk 2 of 5"
+  // "output_format": "text",        // "text" or "markdown"
+  // "output_file_pattern": "%d.txt",  // "%d.md" for markdown
+  // "sections": [],                 // [{"name": "api", "patterns": ["src/api/**"]}] routes files into named outputs
+  // "default_section": "",          // section for files matching no section, which are dropped otherwise
+  // "clean_only_generated": false,  // keep files in the output folder this tool didn't write
+
+  // Which files are merged
+  "respect_gitignore": true,
+  // "respect_ignore_files": [],     // more gitignore-style files, such as ".dockerignore"
+  // "skip_hidden": true,            // skip files and folders starting with "."
+  // "tracked_only": false,          // only merge files git tracks
+  "blacklisted_folders": ["node_modules", ".git", "dist", "build", "vendor"],
+  "ignored_file_types": [
+    ".exe", ".dll", ".so", ".dylib", ".o", ".a", ".class", ".jar",
+    ".png", ".jpg", ".jpeg", ".gif", ".ico", ".pdf", ".zip", ".gz",
+    ".woff", ".woff2", ".ttf", ".lock"
+  ],
+  // "ignore_extension_dirs": false, // also skip folders named like an ignored file type
+  // "allowed_file_types": [],       // only merge these extensions, ignored_file_types is then unused
+  // "include_patterns": [],         // only merge paths matching one of these globs
+  // "exclude_regex": [],            // skip paths matching one of these regular expressions
+  // "include_root_files": [],       // files in a project root are skipped unless listed here
+  // "case_insensitive": false,      // match extensions and blacklisted folders ignoring case
+  // "follow_symlinks": false,
+  // "max_source_file_kb": 0,        // skip larger files, 0 is off
+  // "min_source_file_bytes": 0,     // skip smaller files
+  // "per_extension_max_kb": {},     // {".js": 50} truncates files of an extension instead
+  // "max_files_per_dir": 0,         // merge at most this many files per folder, 0 is off
+  // "content_match": "",            // only merge files whose content matches this regular expression
+  "skip_binary_files": true,
+  // "skip_empty_files": false,
+  // "skip_invalid_utf8": false,
+  // "replace_invalid_utf8": false,  // takes precedence over skip_invalid_utf8
+
+  // How merged files are written
+  // "normalize_line_endings": false, // convert CRLF to LF and drop the BOM
+  // "trim_trailing_whitespace": false,
+  // "collapse_blank_lines": false,
+  // "line_numbers": false,
+  // "deduplicate_content": false,   // replace repeated files with a reference to the first
+  // "transforms": {},               // {".json": "jq -c ."} pipes an extension through a command
+  // "sort_by": "path",              // "path", "size", "extension" or "mtime"
+  // "include_tree": false,          // start with a tree of the merged files
+  // "header_metadata": false,       // add size and modification time to headers
+  // "header_path_style": "relative", // "relative", "absolute" or "project-prefixed"
+  // "header_template": "",          // Go template with .Path, .Size, .Lang and .Part
+  // "file_separator": "\n\n",
+  // "language_map": {},             // {".tsx": "typescript"} for markdown code blocks
+  // "preamble": "",                 // text the first output file starts with
+  // "preamble_file": "",
+  // "footer": "",                   // text the last output file ends with
+  // "footer_file": "",
+  // "footer_summary": false,        // list the merged files after the footer
+  // "warn_on_leading_comment": false, // warn about files starting with a comment
+
+  // Running the tool
+  // "workers": 0,                   // files read at once, 0 uses every CPU
+  // "read_retries": 0,              // retries for files that fail to read
+  // "fzf_args": [],                 // extra fzf options, such as "--height=40%"
+  // "editor": "",                   // used by --open, defaults to $EDITOR
+  // "post_merge_command": "",       // run after merging, with $FILEMERGE_OUTPUT set
+  // "warnings_log": ""              // append warnings to this file instead of the console
+}
+`
+
+func writeDefaultConfig(configPath string, force bool) error {
+    if configPath == "-" {
+        return errors.New("--init needs a file path, not stdin")
+    }
+    if _, err := os.Stat(configPath); err == nil && !force {
+        return fmt.Errorf("%s already exists, rerun with --force to overwrite it", configPath)
+    }
+    return os.WriteFile(configPath, []byte(defaultConfig), 0644)
+}
+
+// loadConfig reads one or more config files. Each file is layered on top
+// of the ones before it: lists are appended, maps merged and other set
+// values replace earlier ones.
+func loadConfig(configPaths []string) (Config, error) {
+    var config Config
+    for i, configPath := range configPaths {
+        // Only the first layer starts from the defaults, so later layers
+        // don't reset what earlier ones set
+        var layer Config
+        if i == 0 {
+            layer.RespectGitignore = true
+            layer.SkipHidden = true
+        }
+        layer, err := readConfigFile(configPath, layer)
+        if err != nil {
+            if len(configPaths) > 1 {
+                err = fmt.Errorf("%s: %w", configPath, err)
+            }
+            return Config{}, err
+        }
+
+        if i == 0 {
+            config = layer
+        } else {
+            config = mergeConfigs(config, layer)
+        }
+    }
+
+    // Configs without markers only detect Node.js projects
+    if len(config.ProjectMarkers) == 0 {
+        config.ProjectMarkers = []string{"package.json"}
+    }
+
+    var err error
+    config.excludePatterns, err = compileExcludeRegex(config.ExcludeRegex)
+    if err != nil {
+        return Config{}, err
+    }
+
+    if config.ContentMatch != "" {
+        config.contentPattern, err = regexp.Compile(config.ContentMatch)
+        if err != nil {
+            return Config{}, fmt.Errorf("content_match %q: %w", config.ContentMatch, err)
+        }
+    }
+
+    if config.HeaderTemplate != "" {
+        config.headerTemplate, err = template.New("header").Parse(config.HeaderTemplate)
+        if err == nil {
+            // Catch unknown fields now rather than on the first file
+            err = config.headerTemplate.Execute(io.Discard, headerFields{})
+        }
+        if err != nil {
+            return Config{}, fmt.Errorf("header_template: %w", err)
+        }
+    }
+
+    if config.FileSeparator == "" {
+        config.FileSeparator = "\n\n"
+    }
+
+    config.LanguageMap = normalizeExtensionMap(config.LanguageMap)
+    config.Transforms = normalizeExtensionMap(config.Transforms)
+    config.PerExtensionMaxKB = normalizeExtensionMap(config.PerExtensionMaxKB)
+
+    if config.ScanDepth <= 0 {
+        config.ScanDepth = 1
+    }
+
+    if config.Workers <= 0 {
+        config.Workers = runtime.NumCPU()
+    }
+
+    switch config.OutputFormat {
+    case "":
+        config.OutputFormat = "text"
+    case "text", "markdown":
+    default:
+        return Config{}, fmt.Errorf("unknown output_format %q (expected \"text\" or \"markdown\")", config.OutputFormat)
+    }
+
+    switch config.SplitStrategy {
+    case "":
+        config.SplitStrategy = "size"
+    case "size", "directory", "per-file", "mirror":
+    default:
+        return Config{}, fmt.Errorf("unknown split_strategy %q (expected \"size\", \"directory\", \"per-file\" or \"mirror\")", config.SplitStrategy)
+    }
+
+    switch config.SortBy {
+    case "":
+        config.SortBy = "path"
+    case "path", "size", "extension", "mtime":
+    default:
+        return Config{}, fmt.Errorf("unknown sort_by %q (expected \"path\", \"size\", \"extension\" or \"mtime\")", config.SortBy)
+    }
+
+    switch config.HeaderPathStyle {
+    case "":
+        config.HeaderPathStyle = "relative"
+    case "relative", "absolute", "project-prefixed":
+    default:
+        return Config{}, fmt.Errorf("unknown header_path_style %q (expected \"relative\", \"absolute\" or \"project-prefixed\")", config.HeaderPathStyle)
+    }
+
+    defaultPattern := "%d.txt"
+    if config.OutputFormat == "markdown" {
+        defaultPattern = "%d.md"
+    }
+    if config.OutputFilePattern == "" {
+        config.OutputFilePattern = defaultPattern
+    } else if !validOutputFilePattern(config.OutputFilePattern) {
+        warnf("output_file_pattern %q must be a file name with exactly one integer verb, using %q", config.OutputFilePattern, defaultPattern)
+        config.OutputFilePattern = defaultPattern
+    }
+
+    return config, nil
+}
+
+func compileExcludeRegex(exprs []string) ([]*regexp.Regexp, error) {
+    var patterns []*regexp.Regexp
+    for i, expr := range exprs {
+        re, err := regexp.Compile(expr)
+        if err != nil {
+            return nil, fmt.Errorf("exclude_regex[%d] %q: %w", i, expr, err)
+        }
+        patterns = append(patterns, re)
+    }
+    return patterns, nil
+}
+
+// normalizeExtensionMap lowercases the extension keys of language_map,
+// transforms or per_extension_max_kb and adds the leading dot when it was
+// left out
+func normalizeExtensionMap[V any](extensionMap map[string]V) map[string]V {
+    if extensionMap == nil {
+        return nil
+    }
+    normalized := map[string]V{}
+    for ext, value := range extensionMap {
+        ext = strings.ToLower(ext)
+        if !strings.HasPrefix(ext, ".") {
+            ext = "." + ext
+        }
+        normalized[ext] = value
+    }
+    return normalized
+}
+
+// readConfigFile decodes a single config file on top of defaults and
+// resolves its paths against the file's folder
+func readConfigFile(configPath string, defaults Config) (Config, error) {
+    var content []byte
+    var err error
+    configName := filepath.Base(configPath)
+    if configPath == "-" {
+        content, err = io.ReadAll(os.Stdin)
+        configName = "stdin"
+    } else {
+        content, err = os.ReadFile(configPath)
+    }
+    if err != nil {
+        return Config{}, err
+    }
+    content, err = stripJSONComments(content)
+    if err != nil {
+        return Config{}, err
+    }
+
+    config := defaults
+    decoder := json.NewDecoder(bytes.NewReader(content))
+    decoder.DisallowUnknownFields()
+    if err := decoder.Decode(&config); err != nil {
+        if !strings.HasPrefix(err.Error(), "json: unknown field") {
+            return Config{}, err
+        }
+
+        // Unknown keys are most likely typos, but shouldn't stop the run
+        warnf("%s: %v", configName, err)
+        config = defaults
+        err = json.Unmarshal(content, &config)
+        if err != nil {
+            return Config{}, err
+        }
+    }
+
+    config.setKeys, err = configKeys(content)
+    if err != nil {
+        return Config{}, err
+    }
+
+    configDir, err := configDirOf(configPath)
+    if err != nil {
+        return Config{}, err
+    }
+    return config, resolveConfigPaths(&config, configDir)
+}
+
+// configDirOf returns the folder paths in a config file are relative to,
+// which is the working directory for a config read from stdin
+func configDirOf(configPath string) (string, error) {
+    if configPath != "-" {
+        return filepath.Dir(configPath), nil
+    }
+    dir, err := os.Getwd()
+    if err != nil {
+        return "", fmt.Errorf("getting working directory: %w", err)
+    }
+    return dir, nil
+}
+
+// resolveConfigPaths expands the paths set in a config and makes them
+// absolute, relative to configDir
+func resolveConfigPaths(config *Config, configDir string) error {
+    var err error
+    resolve := func(field string, path *string) {
+        if err != nil || *path == "" {
+            return
+        }
+        expanded, expandErr := expandPath(*path)
+        if expandErr != nil {
+            err = fmt.Errorf("%s: %w", field, expandErr)
+            return
+        }
+        *path = resolveRelativePath(configDir, expanded)
+    }
+
+    resolve("output_folder", &config.OutputFolder)
+    resolve("root_folder", &config.RootFolder)
+    for i := range config.RootFolders {
+        resolve(fmt.Sprintf("root_folders[%d]", i), &config.RootFolders[i])
+    }
+    resolve("preamble_file", &config.PreambleFile)
+    resolve("footer_file", &config.FooterFile)
+    resolve("warnings_log", &config.WarningsLog)
+    return err
+}
+
+const projectConfigName = ".filemerge.json"
+
+// projectConfigKeys are the settings a .filemerge.json may change: which
+// files are picked and how their content is prepared. Output settings stay
+// shared by all selected projects, and settings running commands, such as
+// transforms, stay in the global config so a cloned project can't run any.
+var projectConfigKeys = []string{
+    "blacklisted_folders", "ignored_file_types", "allowed_file_types", "include_patterns",
+    "include_root_files", "exclude_regex", "respect_gitignore", "respect_ignore_files",
+    "skip_hidden", "follow_symlinks", "case_insensitive", "ignore_extension_dirs",
+    "tracked_only", "max_source_file_kb", "min_source_file_bytes", "max_files_per_dir",
+    "per_extension_max_kb", "skip_binary_files", "skip_empty_files", "skip_invalid_utf8",
+    "replace_invalid_utf8", "content_match", "normalize_line_endings",
+    "trim_trailing_whitespace", "collapse_blank_lines", "line_numbers",
+}
+
+// loadProjectConfig applies the .filemerge.json of a project, if any, on
+// top of the global config
+func loadProjectConfig(project string, base Config) (Config, error) {
+    path := filepath.Join(project, projectConfigName)
+    content, err := os.ReadFile(path)
+    if os.IsNotExist(err) {
+        return base, nil
+    }
+    if err != nil {
+        return Config{}, err
+    }
+
+    var override Config
+    content, err = stripJSONComments(content)
+    if err != nil {
+        return Config{}, fmt.Errorf("%s: %w", path, err)
+    }
+    if err := json.Unmarshal(content, &override); err != nil {
+        return Config{}, fmt.Errorf("%s: %w", path, err)
+    }
+    override.setKeys, err = configKeys(content)
+    if err != nil {
+        return Config{}, fmt.Errorf("%s: %w", path, err)
+    }
+
+    var unsupported []string
+    for key := range override.setKeys {
+        if !slices.Contains(projectConfigKeys, key) {
+            unsupported = append(unsupported, key)
+        }
+    }
+    if len(unsupported) > 0 {
+        sort.Strings(unsupported)
+        return Config{}, &exitError{exitConfigError, fmt.Errorf("%s: %s can only be set in the global config", path, strings.Join(unsupported, ", "))}
+    }
+
+    override.PerExtensionMaxKB = normalizeExtensionMap(override.PerExtensionMaxKB)
+    override.excludePatterns, err = compileExcludeRegex(override.ExcludeRegex)
+    if err != nil {
+        return Config{}, fmt.Errorf("%s: %w", path, err)
+    }
+
+    config := mergeConfigs(base, override)
+    if override.setKeys["content_match"] {
+        config.contentPattern = nil
+        if config.ContentMatch != "" {
+            config.contentPattern, err = regexp.Compile(config.ContentMatch)
+            if err != nil {
+                return Config{}, fmt.Errorf("%s: content_match %q: %w", path, config.ContentMatch, err)
+            }
+        }
+    }
+
+    if problems := validateConfig(config); len(problems) > 0 {
+        message := "invalid config " + path + ":"
+        for _, problem := range problems {
+            message += "\n  - " + problem.Error()
+        }
+        return Config{}, &exitError{exitConfigError, errors.New(message)}
+    }
+    return config, nil
+}
+
+// configKeys returns the lowercased top-level keys of a config file, so
+// settings set to false, 0 or "" can be told apart from unset ones
+func configKeys(content []byte) (map[string]bool, error) {
+    var raw map[string]json.RawMessage
+    if err := json.Unmarshal(content, &raw); err != nil {
+        return nil, err
+    }
+    keys := map[string]bool{}
+    for key := range raw {
+        keys[strings.ToLower(key)] = true
+    }
+    return keys, nil
+}
+
+// configKey returns the JSON key of a Config field
+func configKey(field reflect.StructField) string {
+    name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+    return name
+}
+
+// mergeConfigs layers override on top of base. Lists are appended, maps
+// merged and other settings replaced, for the keys the override file sets.
+// Without the keys of a file, non-zero settings replace earlier ones.
+func mergeConfigs(base, override Config) Config {
+    merged := base
+    mergedValue := reflect.ValueOf(&merged).Elem()
+    overrideValue := reflect.ValueOf(override)
+    for i := 0; i < mergedValue.NumField(); i++ {
+        field := mergedValue.Field(i)
+        value := overrideValue.Field(i)
+        if !field.CanSet() {
+            continue
+        }
+        if override.setKeys != nil && !override.setKeys[configKey(mergedValue.Type().Field(i))] {
+            continue
+        }
+
+        switch {
+        case field.Kind() == reflect.Slice:
+            // Copy first so merges for different projects don't share storage
+            combined := reflect.MakeSlice(field.Type(), 0, field.Len()+value.Len())
+            combined = reflect.AppendSlice(combined, field)
+            field.Set(reflect.AppendSlice(combined, value))
+        case field.Kind() == reflect.Map && !value.IsNil():
+            combined := reflect.MakeMap(field.Type())
+            for _, source := range []reflect.Value{field, value} {
+                iter := source.MapRange()
+                for iter.Next() {
+                    combined.SetMapIndex(iter.Key(), iter.Value())
+                }
+            }
+            field.Set(combined)
+        case override.setKeys != nil || !value.IsZero():
+            field.Set(value)
+        }
+    }
+
+    merged.excludePatterns = append(append([]*regexp.Regexp(nil), base.excludePatterns...), override.excludePatterns...)
+    return merged
+}
+
+// allRootFolders returns root_folder followed by root_folders, skipping
+// an unset root_folder and repeated entries
+func allRootFolders(c Config) []string {
+    var roots []string
+    seen := map[string]bool{}
+    for _, root := range append([]string{c.RootFolder}, c.RootFolders...) {
+        if root != "" && !seen[root] {
+            seen[root] = true
+            roots = append(roots, root)
+        }
+    }
+    return roots
+}
+
+// rootFolderOf returns the root folder a project was found in
+func rootFolderOf(project string, c Config) string {
+    for _, root := range allRootFolders(c) {
+        if isSubpath(root, project) {
+            return root
+        }
+    }
+    return filepath.Dir(project)
+}
+
+func validateConfig(c Config) []error {
+    var problems []error
+
+    for _, root := range allRootFolders(c) {
+        info, err := os.Stat(root)
+        if os.IsNotExist(err) {
+            problems = append(problems, fmt.Errorf("root folder %s does not exist", root))
+        } else if err != nil {
+            problems = append(problems, fmt.Errorf("root folder: %w", err))
+        } else if !info.IsDir() {
+            problems = append(problems, fmt.Errorf("root folder %s is not a directory", root))
+        }
+    }
+
+    if c.MaxFileSizeMB <= 0 && c.MaxTokensPerFile <= 0 {
+        problems = append(problems, fmt.Errorf("max_file_size_mb must be greater than 0"))
+    }
+
+    for i, folder := range c.BlacklistedFolders {
+        if strings.TrimSpace(folder) == "" {
+            problems = append(problems, fmt.Errorf("blacklisted_folders[%d] is empty", i))
+        }
+    }
+
+    for i, ext := range c.IgnoredFileTypes {
+        if strings.TrimSpace(ext) == "" {
+            problems = append(problems, fmt.Errorf("ignored_file_types[%d] is empty and would ignore every file", i))
+        }
+    }
+
+    for i, ext := range c.AllowedFileTypes {
+        if strings.TrimSpace(ext) == "" {
+            problems = append(problems, fmt.Errorf("allowed_file_types[%d] is empty and would allow every file", i))
+        }
+    }
+
+    sectionNames := map[string]bool{}
+    for i, section := range c.Sections {
+        if section.Name == "" || strings.ContainsAny(section.Name, `/\`) {
+            problems = append(problems, fmt.Errorf("sections[%d] needs a name usable as a file name", i))
+        } else if sectionNames[section.Name] {
+            problems = append(problems, fmt.Errorf("sections[%d]: section %q is defined twice", i, section.Name))
+        }
+        sectionNames[section.Name] = true
+    }
+    if strings.ContainsAny(c.DefaultSection, `/\`) {
+        problems = append(problems, errors.New("default_section needs a name usable as a file name"))
+    }
+    if len(c.Sections) > 0 && c.SplitStrategy != "size" {
+        problems = append(problems, fmt.Errorf("sections cannot be combined with split_strategy %q", c.SplitStrategy))
+    }
+
+    return problems
+}
+
+// sourceFile is a file selected for merging by the walk
+type sourceFile struct {
+    path    string
+    relPath string
+    info    fs.FileInfo
+
+    // Output file the file goes to when splitting by group: its top-level
+    // folder, section or, for per-file and mirror, its own path
+    group string
+
+    project string
+
+    // Commit the file is read from instead of the working tree, see --git-ref
+    gitRef string
+
+    // Files left out of this file's folder by max_files_per_dir
+    omitted int
+
+    // Settings of the file's project, including its .filemerge.json
+    config *Config
+}
+
+// headerPath returns the path shown in the header of a merged file
+func headerPath(file sourceFile, style string) string {
+    switch style {
+    case "absolute":
+        return file.path
+    case "project-prefixed":
+        relPath, err := filepath.Rel(file.project, file.path)
+        if err != nil {
+            return file.relPath
+        }
+        return filepath.Join(filepath.Base(file.project), relPath)
+    }
+    return file.relPath
+}
+
+// sortFiles orders the collected files by the sort_by setting. Files that
+// compare equal keep their walk order, which is already sorted by path.
+func sortFiles(files []sourceFile, sortBy string) {
+    var less func(a, b sourceFile) bool
+    switch sortBy {
+    case "size":
+        less = func(a, b sourceFile) bool { return a.info.Size() < b.info.Size() }
+    case "extension":
+        less = func(a, b sourceFile) bool {
+            return strings.ToLower(filepath.Ext(a.relPath)) < strings.ToLower(filepath.Ext(b.relPath))
+        }
+    case "mtime":
+        less = func(a, b sourceFile) bool { return a.info.ModTime().Before(b.info.ModTime()) }
+    default:
+        return
+    }
+
+    sort.SliceStable(files, func(i, j int) bool {
+        return less(files[i], files[j])
+    })
+}
+
+// collectProjectFiles walks a project and returns the files that pass all
+// filters, in walk order
+func collectProjectFiles(ctx context.Context, project string, config Config, stats *mergeStats) ([]sourceFile, error) {
+    var files []sourceFile
+
+    // A subfolder picked with --pick-subdir is walked on its own, but keeps
+    // the overrides and ignore rules of its project
+    projectRoot := project
+    if parent, ok := config.subdirProjects[project]; ok {
+        projectRoot = parent
+    }
+
+    // Project overrides only affect which files are picked, output settings
+    // stay shared by all selected projects
+    config, err := loadProjectConfig(projectRoot, config)
+    if err != nil {
+        return nil, err
+    }
+
+    // .filemergeignore files in the root folder and within the project
+    rootPatterns, err := loadIgnoreFile(filepath.Join(rootFolderOf(projectRoot, config), ".filemergeignore"))
+    if err != nil {
+        return nil, err
+    }
+    mergeignore, err := loadIgnoreMatcher(projectRoot, []string{".filemergeignore"}, rootPatterns)
+    if err != nil {
+        return nil, err
+    }
+
+    // Honor .gitignore and the respect_ignore_files within the project
+    var gitignore *ignoreMatcher
+    ignoreFileNames := config.RespectIgnoreFiles
+    if config.RespectGitignore {
+        ignoreFileNames = append([]string{".gitignore"}, ignoreFileNames...)
+    }
+    if len(ignoreFileNames) > 0 {
+        gitignore, err = loadIgnoreMatcher(projectRoot, ignoreFileNames, nil)
+        if err != nil {
+            return nil, err
+        }
+    }
+
+    // Files kept and left out per folder, for max_files_per_dir
+    filesPerDir := map[string]int{}
+    omittedPerDir := map[string]int{}
+    lastInDir := map[string]int{}
+
+    var changed map[string]bool
+    if config.changedSince != "" {
+        changed, err = changedFiles(project, config.changedSince, config.gitRef)
+        if err != nil {
+            return nil, err
+        }
+    }
+
+    // With tracked_only, projects in a git repository only merge tracked
+    // files. A ref only holds tracked files anyway.
+    var tracked map[string]bool
+    if config.TrackedOnly && config.gitRef == "" {
+        tracked, err = trackedFiles(project)
+        if err != nil {
+            return nil, err
+        }
+        if tracked == nil {
+            debugf("%s isn't in a git repository, merging untracked files too", project)
+        }
+    }
+
+    visit := func(path string, info fs.FileInfo, err error) error {
+        if err != nil {
+            return err
+        }
+        if ctx.Err() != nil {
+            return ctx.Err()
+        }
+
+        relPath, err := filepath.Rel(project, path)
+        if err != nil {
+            return err
+        }
+
+        // Never merge the tool's own output
+        if info.IsDir() && isSkippedPath(path, config.skipPaths) {
+            stats.logSkip(relPath, "output folder")
+            return filepath.SkipDir
+        }
+
+        // Skip dotfiles and dot-folders such as .git and .env, unless the
+        // file is allowlisted in the project root
+        if relPath != "." && config.SkipHidden && strings.HasPrefix(info.Name(), ".") &&
+            !(!info.IsDir() && isInRoot(projectRoot, path) && isIncludedRootFile(info.Name(), config.IncludeRootFiles)) {
+            stats.skippedHidden++
+            stats.logSkip(relPath, "hidden")
+            if info.IsDir() {
+                return filepath.SkipDir
+            }
+            return nil
+        }
+
+        // Skip blacklisted folders. A folder with negated entries that may
+        // re-include some of its contents is walked, and its files checked
+        // one by one.
+        if relPath != "." && isBlacklisted(relPath, config.BlacklistedFolders, info.IsDir(), config.CaseInsensitive) {
+            if !info.IsDir() {
+                stats.skippedBlacklist++
+                stats.logSkip(relPath, "in blacklisted folder")
+                return nil
+            }
+            if !mayReinclude(relPath, config.BlacklistedFolders, config.CaseInsensitive) {
+                stats.skippedBlacklist++
+                stats.logSkip(relPath, "blacklisted folder")
+                return filepath.SkipDir
+            }
+        }
+
+        // Skip paths matching an exclude regex
+        if relPath != "." && matchesAnyRegex(filepath.ToSlash(relPath), config.excludePatterns) {
+            stats.skippedRegex++
+            stats.logSkip(relPath, "matches exclude_regex")
+            if info.IsDir() {
+                return filepath.SkipDir
+            }
+            return nil
+        }
+
+        // Skip paths excluded by .filemergeignore
+        if mergeignore.isIgnored(path, info.IsDir()) {
+            stats.skippedMergeignore++
+            stats.logSkip(relPath, "ignored by .filemergeignore")
+            if info.IsDir() {
+                return filepath.SkipDir
+            }
+            return nil
+        }
+        if info.IsDir() {
+            if err := mergeignore.loadDir(path); err != nil {
+                return err
+            }
+        }
+
+        // Skip paths excluded by .gitignore and the respect_ignore_files
+        if gitignore != nil {
+            if gitignore.isIgnored(path, info.IsDir()) {
+                stats.skippedGitignore++
+                stats.logSkip(relPath, "ignored by "+strings.Join(ignoreFileNames, " or "))
+                if info.IsDir() {
+                    return filepath.SkipDir
+                }
+                return nil
+            }
+            if info.IsDir() {
+                if err := gitignore.loadDir(path); err != nil {
+                    return err
+                }
+            }
+        }
+
+        // Folders named like an ignored file type, such as report.json/,
+        // are skipped along with their contents when asked to
+        if info.IsDir() && relPath != "." && config.IgnoreExtensionDirs && hasIgnoredExtension(path, config.IgnoredFileTypes, config.CaseInsensitive) {
+            stats.skippedExtension++
+            stats.logSkip(relPath, "folder named like an ignored extension")
+            return filepath.SkipDir
+        }
+
+        // Only files in subdirectories are merged
+        if info.IsDir() {
+            return nil
+        }
+
+        // Without follow_symlinks, links to folders (and dangling links) are left out
+        if info.Mode()&os.ModeSymlink != 0 {
+            if target, err := os.Stat(path); err != nil || target.IsDir() {
+                stats.logSkip(relPath, "symlink not followed")
+                return nil
+            }
+        }
+
+        // Ignore files in the root directory of the project, except allowlisted ones
+        if isInRoot(projectRoot, path) && !isIncludedRootFile(info.Name(), config.IncludeRootFiles) {
+            stats.skippedRoot++
+            stats.logSkip(relPath, "in project root")
+            return nil // Skip this file
+        }
+
+        // With an allowlist, only the listed extensions are merged and the
+        // denylist is ignored
+        if len(config.AllowedFileTypes) > 0 {
+            if !hasAllowedExtension(path, config.AllowedFileTypes, config.CaseInsensitive) {
+                stats.skippedNotAllowed++
+                stats.logSkip(relPath, "extension not allowed")
+                return nil
+            }
+        } else if hasIgnoredExtension(path, config.IgnoredFileTypes, config.CaseInsensitive) {
+            // Ignore files with specific extensions (e.g., binaries)
+            stats.skippedExtension++
+            stats.logSkip(relPath, "ignored extension")
+            return nil // Skip this file type
+        }
+
+        // When include patterns are set, only files matching one of them are merged
+        if len(config.IncludePatterns) > 0 && !matchesInclude(relPath, config.IncludePatterns) {
+            stats.skippedInclude++
+            stats.logSkip(relPath, "not matching include patterns")
+            return nil
+        }
+
+        // Skip single files too large to be useful, such as bundles and
+        // lockfiles. Extensions in per_extension_max_kb are truncated instead.
+        _, truncated := config.PerExtensionMaxKB[strings.ToLower(filepath.Ext(relPath))]
+        if config.MaxSourceFileKB > 0 && !truncated && info.Size() > int64(config.MaxSourceFileKB)*1024 {
+            stats.skippedTooLarge = append(stats.skippedTooLarge, fmt.Sprintf("%s (%d bytes)", relPath, info.Size()))
+            stats.logSkip(relPath, "larger than max_source_file_kb")
+            return nil
+        }
+
+        // Skip trivial files such as one-line re-exports
+        if config.MinSourceFileBytes > 0 && info.Size() < int64(config.MinSourceFileBytes) {
+            stats.skippedTooSmall++
+            stats.logSkip(relPath, "smaller than min_source_file_bytes")
+            return nil
+        }
+
+        // With --changed-since, only files changed since that ref are merged
+        if changed != nil && !changed[filepath.ToSlash(relPath)] {
+            stats.skippedUnchanged++
+            stats.logSkip(relPath, "unchanged since "+config.changedSince)
+            return nil
+        }
+
+        if tracked != nil && !tracked[filepath.ToSlash(relPath)] {
+            stats.skippedUntracked++
+            stats.logSkip(relPath, "not tracked by git")
+            return nil
+        }
+
+        // Sample large folders by keeping only their first files
+        if config.MaxFilesPerDir > 0 {
+            dir := filepath.Dir(relPath)
+            if filesPerDir[dir] >= config.MaxFilesPerDir {
+                omittedPerDir[dir]++
+                stats.skippedPerDir++
+                stats.logSkip(relPath, "over max_files_per_dir")
+                return nil
+            }
+            filesPerDir[dir]++
+            lastInDir[dir] = len(files)
+        }
+
+        files = append(files, sourceFile{path: path, relPath: relPath, info: info, group: topLevelDir(relPath), project: project, gitRef: config.gitRef, config: &config})
+        return nil
+    }
+
+    switch {
+    case config.gitRef != "":
+        err = walkGitRef(project, config.gitRef, visit)
+    case config.FollowSymlinks:
+        err = walkFollowingSymlinks(project, visit, func(path string) {
+            stats.skippedCycles = append(stats.skippedCycles, path)
+        })
+    default:
+        err = filepath.Walk(project, visit)
+    }
+
+    // The last file kept from a sampled folder notes what was left out
+    for dir, omitted := range omittedPerDir {
+        files[lastInDir[dir]].omitted = omitted
+    }
+    return files, err
+}
+
+// expandPath expands environment variables and a leading ~ or ~user
+func expandPath(path string) (string, error) {
+    path = os.ExpandEnv(path)
+    if !strings.HasPrefix(path, "~") {
+        return path, nil
+    }
+
+    name, rest, _ := strings.Cut(path[1:], "/")
+    if name == "" {
+        homeDir, err := os.UserHomeDir()
+        if err != nil {
+            return "", err
+        }
+        return filepath.Join(homeDir, rest), nil
+    }
+
+    account, err := user.Lookup(name)
+    if err != nil {
+        return "", fmt.Errorf("can't expand ~%s: %w", name, err)
+    }
+    return filepath.Join(account.HomeDir, rest), nil
+}
+
+func cleanOutputDirectory(outputDir string, config Config) error {
+    // With clean_only_generated, files the tool didn't write are kept
+    if config.CleanOnlyGenerated {
+        if err := os.MkdirAll(outputDir, os.ModePerm); err != nil {
+            return err
+        }
+        if config.SplitStrategy != "mirror" {
+            return removeGeneratedFiles(outputDir, outputFileRegexp(config.OutputFilePattern, splitsByGroup(config)), nil)
+        }
+        mirrored, err := loadMirrorList(outputDir)
+        if err != nil {
+            return err
+        }
+        return removeGeneratedFiles(outputDir, nil, mirrored)
+    }
+
+    // Remove the entire output directory and its contents
+    err := os.RemoveAll(outputDir)
+    if err != nil && !os.IsNotExist(err) {
+        return err
+    }
+
+    // Recreate the output directory
+    return os.MkdirAll(outputDir, os.ModePerm)
+}
+
+// removeGeneratedFiles deletes the output files in outputDir, matched by
+// generated, and the mirrored files a mirror run listed. Folders left empty
+// by the mirrored files are removed too.
+func removeGeneratedFiles(outputDir string, generated *regexp.Regexp, mirrored map[string]bool) error {
+    entries, err := os.ReadDir(outputDir)
+    if err != nil {
+        return err
+    }
+    for _, entry := range entries {
+        if entry.IsDir() || !(isToolFile(entry.Name()) || (generated != nil && generated.MatchString(entry.Name()))) {
+            continue
+        }
+        if err := os.Remove(filepath.Join(outputDir, entry.Name())); err != nil {
+            return err
+        }
+    }
+
+    outputDir = filepath.Clean(outputDir)
+    for name := range mirrored {
+        path := filepath.Join(outputDir, filepath.FromSlash(name))
+        if !isSubpath(outputDir, path) || path == outputDir {
+            continue
+        }
+        if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+            return err
+        }
+        for dir := filepath.Dir(path); dir != outputDir; dir = filepath.Dir(dir) {
+            if os.Remove(dir) != nil {
+                break // not empty
+            }
+        }
+    }
+    return nil
+}
+
+// checkOutputFolderSafe refuses output folders that equal or contain one of
+// the protected paths, since the output folder is wiped on every run
+func checkOutputFolderSafe(outputFolder string, protected []string) error {
+    outputFolder = filepath.Clean(outputFolder)
+    if outputFolder == filepath.Dir(outputFolder) {
+        return fmt.Errorf("refusing to use filesystem root %s as the output folder", outputFolder)
+    }
+
+    for _, path := range protected {
+        if path == "" {
+            continue
+        }
+        if isSubpath(outputFolder, path) {
+            return fmt.Errorf("refusing to clean output folder %s because it contains %s", outputFolder, path)
+        }
+    }
+    return nil
+}
+
+// isSubpath reports whether path is parent or lies below it
+func isSubpath(parent string, path string) bool {
+    relPath, err := filepath.Rel(filepath.Clean(parent), filepath.Clean(path))
+    if err != nil {
+        return false
+    }
+    return relPath == "." || (relPath != ".." && !strings.HasPrefix(relPath, ".."+string(os.PathSeparator)))
+}
+
+// hasForeignFiles reports whether the output folder holds anything besides
+// output files and the manifest from a previous run. With mirrored set, for
+// the mirror split strategy, the output files are the ones it lists.
+func hasForeignFiles(outputFolder string, pattern string, anyName bool, mirrored map[string]bool) (bool, error) {
+    if mirrored != nil {
+        foreign := false
+        err := filepath.WalkDir(outputFolder, func(path string, entry fs.DirEntry, err error) error {
+            if err != nil || entry.IsDir() {
+                return err
+            }
+            relPath, _ := filepath.Rel(outputFolder, path)
+            relPath = filepath.ToSlash(relPath)
+            if !mirrored[relPath] && !isToolFile(relPath) {
+                foreign = true
+                return filepath.SkipAll
+            }
+            return nil
+        })
+        if os.IsNotExist(err) {
+            return false, nil
+        }
+        return foreign, err
+    }
+
+    entries, err := os.ReadDir(outputFolder)
+    if os.IsNotExist(err) {
+        return false, nil
+    }
+    if err != nil {
+        return false, err
+    }
+
+    generated := outputFileRegexp(pattern, anyName)
+    for _, entry := range entries {
+        if entry.IsDir() || (!isToolFile(entry.Name()) && !generated.MatchString(entry.Name())) {
+            return true, nil
+        }
+    }
+    return false, nil
+}
+
+// isToolFile reports whether name is one of the fixed files written next to
+// the output files
+func isToolFile(name string) bool {
+    if name == "manifest.json" || name == cacheFileName || name == mirrorListFileName || name == jsonOutputFileName {
+        return true
+    }
+    for _, archiveName := range archiveFileNames {
+        if name == archiveName {
+            return true
+        }
+    }
+    return false
+}
+
+// outputFileRegexp matches the names outputFileName produces for pattern.
+// With anyName set, the verb may also hold a folder name, as produced by
+// directoryOutputFileName.
+func outputFileRegexp(pattern string, anyName bool) *regexp.Regexp {
+    var builder strings.Builder
+    builder.WriteString("^")
+    for i := 0; i < len(pattern); i++ {
+        switch {
+        case pattern[i] == '%' && i+1 < len(pattern) && pattern[i+1] == '%':
+            builder.WriteString("%")
+            i++
+        case pattern[i] == '%':
+            // Skip flags and width up to the verb
+            for i < len(pattern) && pattern[i] != 'd' {
+                i++
+            }
+            if anyName {
+                builder.WriteString(`.+`)
+            } else {
+                builder.WriteString(`\s*[+-]?\d+`)
+            }
+        default:
+            builder.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+        }
+    }
+    builder.WriteString("$")
+    return regexp.MustCompile(builder.String())
+}
+
+func confirm(stdin *bufio.Reader, question string) bool {
+    fmt.Fprintf(logOutput, "%s [y/N] ", question)
+    answer, _ := stdin.ReadString('\n')
+    answer = strings.ToLower(strings.TrimSpace(answer))
+    return answer == "y" || answer == "yes"
+}
+
+// findProjects looks for directories containing a marker file, descending
+// up to config.ScanDepth levels below rootFolder. Found projects and
+// blacklisted folders are not searched any further.
+func findProjects(rootFolder string, config Config) ([]string, error) {
+    var projects []string
+
+    var scan func(dir string, depth int) error
+    scan = func(dir string, depth int) error {
+        entries, err := os.ReadDir(dir)
+        if err != nil {
+            return err
+        }
+
+        for _, entry := range entries {
+            if !entry.IsDir() {
+                continue
+            }
+
+            path := filepath.Join(dir, entry.Name())
+            if isSkippedPath(path, config.skipPaths) {
+                continue
+            }
+            if hasProjectMarker(path, config.ProjectMarkers) {
+                projects = append(projects, path)
+                continue
+            }
+
+            relPath, _ := filepath.Rel(rootFolder, path)
+            if depth < config.ScanDepth && !isBlacklisted(relPath, config.BlacklistedFolders, true, config.CaseInsensitive) {
+                // Unreadable nested folders are skipped rather than fatal
+                scan(path, depth+1)
+            }
+        }
+        return nil
+    }
+
+    return projects, scan(rootFolder, 1)
+}
+
+func isSkippedPath(path string, skipPaths []string) bool {
+    for _, skipPath := range skipPaths {
+        if filepath.Clean(path) == skipPath {
+            return true
+        }
+    }
+    return false
+}
+
+func hasProjectMarker(dir string, markers []string) bool {
+    for _, marker := range markers {
+        if _, err := os.Stat(filepath.Join(dir, marker)); err == nil {
+            return true
+        }
+    }
+    return false
+}
+
+// isBlacklisted matches entries against whole segments of the project-relative
+// path. "build" matches any "build" directory, "/dist" only the top-level one,
+// and glob entries such as "*build*" match segments containing "build".
+func isBlacklisted(relPath string, blacklistedFolders []string, isDir bool, caseInsensitive bool) bool {
+    segments := pathSegments(relPath, caseInsensitive)
+    blacklisted := false
+    for _, folder := range blacklistedFolders {
+        negate := strings.HasPrefix(folder, "!")
+        pattern, anchored := blacklistPattern(strings.TrimPrefix(folder, "!"), caseInsensitive)
+
+        // Entries match a folder or any folder above a path. Negated entries
+        // re-include paths, files included, and the last match wins.
+        candidates := segments
+        if !isDir && !negate {
+            candidates = segments[:len(segments)-1]
+        }
+        for start := 0; start < len(candidates); start++ {
+            if matchesFrom(pattern, candidates[start:]) {
+                blacklisted = !negate
+                break
+            }
+            if anchored {
+                break
+            }
+        }
+    }
+    return blacklisted
+}
+
+// matchesFrom reports whether pattern matches the first segments of path.
+// "**" lets it span any number of them.
+func matchesFrom(pattern []string, path []string) bool {
+    for end := 1; end <= len(path); end++ {
+        if matchSegments(pattern, path[:end]) {
+            return true
+        }
+    }
+    return false
+}
+
+// mayReinclude reports whether a negated blacklist entry could match
+// something inside the blacklisted folder relPath, so it has to be walked
+func mayReinclude(relPath string, blacklistedFolders []string, caseInsensitive bool) bool {
+    segments := pathSegments(relPath, caseInsensitive)
+    for _, folder := range blacklistedFolders {
+        if !strings.HasPrefix(folder, "!") {
+            continue
+        }
+        pattern, anchored := blacklistPattern(strings.TrimPrefix(folder, "!"), caseInsensitive)
+
+        // The start of the entry has to match the end of the folder path
+        for k := 1; k < len(pattern); k++ {
+            if pattern[k-1] == "**" {
+                return true
+            }
+            if k > len(segments) || (anchored && k != len(segments)) {
+                continue
+            }
+            if matchSegments(pattern[:k], segments[len(segments)-k:]) {
+                return true
+            }
+        }
+    }
+    return false
+}
+
+func pathSegments(relPath string, caseInsensitive bool) []string {
+    if caseInsensitive {
+        relPath = strings.ToLower(relPath)
+    }
+    return strings.Split(filepath.ToSlash(relPath), "/")
+}
+
+// blacklistPattern splits a blacklisted_folders entry into segments. A
+// leading slash anchors it to the top of the project.
+func blacklistPattern(folder string, caseInsensitive bool) ([]string, bool) {
+    folder = filepath.ToSlash(folder)
+    if caseInsensitive {
+        folder = strings.ToLower(folder)
+    }
+    return strings.Split(strings.Trim(folder, "/"), "/"), strings.HasPrefix(folder, "/")
+}
+
+func outputFileName(index int, pattern string) string {
+    return fmt.Sprintf(pattern, index)
+}
+
+// splitsByGroup reports whether every output file holds one group of files,
+// such as a top-level folder or a section, rather than filling up to a size
+func splitsByGroup(config Config) bool {
+    switch config.SplitStrategy {
+    case "directory", "per-file", "mirror":
+        return true
+    }
+    return len(config.Sections) > 0
+}
+
+// assignFileGroups gives every file a group of its own for the per-file
+// split strategy, named after its path with slashes replaced by "_"
+func assignFileGroups(files []sourceFile) {
+    used := map[string]bool{}
+    for i := range files {
+        name := strings.ReplaceAll(filepath.ToSlash(files[i].relPath), "/", "_")
+        group := name
+        for n := 2; used[group]; n++ {
+            group = fmt.Sprintf("%s_%d", name, n)
+        }
+        used[group] = true
+        files[i].group = group
+    }
+}
+
+// assignSections sets the group of each file to the first section matching
+// it. Files matching no section go to default_section, or are dropped.
+func assignSections(files []sourceFile, config Config, stats *mergeStats) []sourceFile {
+    var kept []sourceFile
+    for _, file := range files {
+        file.group = config.DefaultSection
+        for _, section := range config.Sections {
+            if matchesInclude(file.relPath, section.Patterns) {
+                file.group = section.Name
+                break
+            }
+        }
+        if file.group == "" {
+            stats.skippedNoSection++
+            stats.logSkip(file.relPath, "matching no section")
+            continue
+        }
+        kept = append(kept, file)
+    }
+    return kept
+}
+
+// directoryOutputFileName puts a folder name in place of the integer verb,
+// so "%d.txt" becomes "src.txt"
+func directoryOutputFileName(dir string, pattern string) string {
+    var builder strings.Builder
+    for i := 0; i < len(pattern); i++ {
+        switch {
+        case pattern[i] == '%' && i+1 < len(pattern) && pattern[i+1] == '%':
+            builder.WriteString("%")
+            i++
+        case pattern[i] == '%':
+            for i < len(pattern) && pattern[i] != 'd' {
+                i++
+            }
+            builder.WriteString(dir)
+        default:
+            builder.WriteByte(pattern[i])
+        }
+    }
+    return builder.String()
+}
+
+// topLevelDir returns the first folder of a project-relative path, or
+// "_root" for files directly in the project
+func topLevelDir(relPath string) string {
+    segments := strings.SplitN(filepath.ToSlash(relPath), "/", 2)
+    if len(segments) < 2 {
+        return "_root"
+    }
+    return segments[0]
+}
+
+// validOutputFilePattern reports whether pattern holds exactly one integer
+// verb (such as %d or %03d) and produces a plain file name
+func validOutputFilePattern(pattern string) bool {
+    verbs := 0
+    for i := 0; i < len(pattern); i++ {
+        if pattern[i] != '%' {
+            continue
+        }
+        i++
+        if i < len(pattern) && pattern[i] == '%' {
+            continue
+        }
+
+        // Skip flags and width
+        for i < len(pattern) && strings.IndexByte("+- 0123456789", pattern[i]) >= 0 {
+            i++
+        }
+        if i >= len(pattern) || pattern[i] != 'd' {
+            return false
+        }
+        verbs++
+    }
+
+    return verbs == 1 && !strings.ContainsAny(pattern, "/\\")
 }
 
-const MB = 1024 * 1024
+func createNewOutputFile(outputDir string, name string) (*os.File, error) {
+    outputPath := filepath.Join(outputDir, name)
+    // Names from the mirror split strategy include folders
+    if err := os.MkdirAll(filepath.Dir(outputPath), os.ModePerm); err != nil {
+        return nil, err
+    }
+    return os.Create(outputPath)
+}
 
-func main() {
-    // Define a flag for the config file path
-    configPath := flag.String("config", "config.json", "Path to the configuration file")
-    flag.Parse()
+// writeFileWithComment writes a source file with its header. part marks
+// the pieces of a split file, such as " (part 2/3)".
+func writeFileWithComment(writer io.Writer, relPath string, part string, info fs.FileInfo, content []byte, config Config) {
+    // Headers read the same on every platform
+    relPath = filepath.ToSlash(relPath)
 
-    // Get the absolute path of the config file
-    absConfigPath, err := filepath.Abs(*configPath)
-    if err != nil {
-        fmt.Println("Error getting absolute path of config file:", err)
+    var header string
+    if config.headerTemplate != nil {
+        var rendered strings.Builder
+        config.headerTemplate.Execute(&rendered, headerFields{Path: relPath, Size: len(content), Lang: languageFor(relPath, config.LanguageMap), Part: part})
+        header = rendered.String()
+    }
+
+    if config.OutputFormat == "markdown" {
+        if header == "" {
+            header = "## " + headerLabel(relPath, info, config) + part
+        }
+        writeFileAsMarkdown(writer, languageFor(relPath, config.LanguageMap), header, content, config.FileSeparator)
         return
     }
 
-    // Get the directory of the config file
-    configDir := filepath.Dir(absConfigPath)
+    prefix, suffix := commentPrefixFor(relPath)
+    // A leading comment, such as a license header, right below the path
+    // header can be mistaken for part of it by tools that parse the output
+    if config.WarnOnLeadingComment && startsWithComment(content) {
+        warnf("%s starts with a comment, which may be read as part of its %q path header", relPath, prefix)
+    }
 
-    // Load configuration
-    config, err := loadConfig(absConfigPath)
-    if err != nil {
-        fmt.Println("Error loading config:", err)
-        return
+    if header == "" {
+        header = prefix + " " + headerLabel(relPath, info, config) + part
+        if suffix != "" {
+            header += " " + suffix
+        }
     }
 
-    // Resolve paths relative to the config file location
-    outputFolder := resolveRelativePath(configDir, config.OutputFolder)
-    rootFolder := resolveRelativePath(configDir, expandPath(config.RootFolder))
+    io.WriteString(writer, header+"\n")
+    writer.Write(content)
+    io.WriteString(writer, config.FileSeparator)
+}
 
-    fmt.Printf("Config file: %s\n", absConfigPath)
-    fmt.Printf("Output folder: %s\n", outputFolder)
-    fmt.Printf("Root folder: %s\n", rootFolder)
+// splitAtLines cuts content into parts whose measure stays within limit,
+// breaking only between lines. A single line over the limit becomes a part
+// of its own.
+func splitAtLines(content []byte, limit int, measure func([]byte) int) [][]byte {
+    var parts [][]byte
+    start := 0
+    for offset := 0; offset < len(content); {
+        end := bytes.IndexByte(content[offset:], '\n')
+        if end < 0 {
+            end = len(content)
+        } else {
+            end += offset + 1
+        }
 
-    // Clean output directory
-    err = cleanOutputDirectory(outputFolder)
-    if err != nil {
-        fmt.Println("Error cleaning output directory:", err)
-        return
+        // Measure the whole part, token estimates don't add up line by line
+        if offset > start && measure(content[start:end]) > limit {
+            parts = append(parts, content[start:offset])
+            start = offset
+        }
+        offset = end
     }
+    return append(parts, content[start:])
+}
 
-    // Find Node.js projects (those with package.json) at the top level
-    nodeProjects, err := findTopLevelNodeProjects(rootFolder)
-    if err != nil {
-        fmt.Println("Error scanning projects:", err)
-        return
+// entryLineCount estimates the lines a source file takes up in the output,
+// including its header and the separator after it
+func entryLineCount(content []byte, config Config) int {
+    lines := bytes.Count(content, []byte("\n")) + strings.Count(config.FileSeparator, "\n") + 1
+    if len(content) > 0 && content[len(content)-1] != '\n' {
+        lines++
     }
-
-    if len(nodeProjects) == 0 {
-        fmt.Println("No Node.js projects found.")
-        return
+    if config.OutputFormat == "markdown" {
+        // Blank line after the heading and the two fence lines
+        lines += 3
     }
+    return lines
+}
 
-    // Let the user select a project using fzf
-    selectedProject, err := selectProjectWithFzf(nodeProjects)
-    if err != nil {
-        fmt.Println("Error selecting project:", err)
-        return
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// normalizeLineEndings strips a UTF-8 BOM and converts CRLF (and lone CR)
+// line endings to LF. No trailing newline is added since the separator
+// written after each file already ends the last line.
+func normalizeLineEndings(content []byte) []byte {
+    content = bytes.TrimPrefix(content, utf8BOM)
+    if bytes.IndexByte(content, '\r') < 0 {
+        return content
     }
+    content = bytes.ReplaceAll(content, []byte("\r\n"), []byte("\n"))
+    return bytes.ReplaceAll(content, []byte("\r"), []byte("\n"))
+}
 
-    fmt.Printf("Selected project: %s\n", selectedProject)
+// omittedNote tells readers of the output that a folder was sampled. The
+// note follows files of any language, so it uses the comment style of the
+// output rather than of the file it follows.
+func omittedNote(file sourceFile, config Config) string {
+    text := fmt.Sprintf("(%d more files in %s omitted)", file.omitted, filepath.ToSlash(filepath.Dir(file.relPath)))
+    if config.OutputFormat == "markdown" {
+        return "_" + text + "_" + config.FileSeparator
+    }
+    return "// " + text + config.FileSeparator
+}
 
-    // Process the selected project
-    outputFileIndex := 1
-    currentFileSize := 0
-    var outputFile *os.File
+// numberLines prefixes every line with its line number, as in "   12| code"
+func numberLines(content []byte) []byte {
+    lines := strings.SplitAfter(string(content), "\n")
+    if lines[len(lines)-1] == "" {
+        lines = lines[:len(lines)-1]
+    }
 
-    err = filepath.Walk(selectedProject, func(path string, info fs.FileInfo, err error) error {
-        if err != nil {
-            return err
+    var numbered strings.Builder
+    for i, line := range lines {
+        if line == "\n" {
+            fmt.Fprintf(&numbered, "%5d|\n", i+1)
+        } else {
+            fmt.Fprintf(&numbered, "%5d| %s", i+1, line)
         }
+    }
+    return []byte(numbered.String())
+}
 
-        // Skip blacklisted folders
-        if info.IsDir() && isBlacklisted(path, config.BlacklistedFolders) {
-            return filepath.SkipDir
+// truncateContent cuts content to at most limit bytes, without splitting a
+// UTF-8 character, and notes how much was left out in a comment
+func truncateContent(content []byte, limit int, relPath string) []byte {
+    cut := limit
+    for cut > 0 && !utf8.RuneStart(content[cut]) {
+        cut--
+    }
+
+    prefix, suffix := commentPrefixFor(relPath)
+    note := fmt.Sprintf("%s ...truncated (%d bytes omitted)", prefix, len(content)-cut)
+    if suffix != "" {
+        note += " " + suffix
+    }
+
+    truncated := append([]byte{}, content[:cut]...)
+    if cut > 0 && truncated[cut-1] != '\n' {
+        truncated = append(truncated, '\n')
+    }
+    return append(truncated, note+"\n"...)
+}
+
+// trimTrailingWhitespace strips spaces and tabs from the end of every line
+// and leaves exactly one newline at the end of non-empty content
+func trimTrailingWhitespace(content []byte) []byte {
+    lines := bytes.Split(content, []byte("\n"))
+    for i, line := range lines {
+        crlf := bytes.HasSuffix(line, []byte("\r"))
+        line = bytes.TrimRight(bytes.TrimSuffix(line, []byte("\r")), " \t")
+        if crlf {
+            line = append(line, '\r')
         }
+        lines[i] = line
+    }
 
-        // Ignore files in the root directory of the selected project
-        if !info.IsDir() && isInRoot(selectedProject, path) {
-            return nil // Skip this file
+    content = bytes.TrimRight(bytes.Join(lines, []byte("\n")), "\r\n")
+    if len(content) == 0 {
+        return content
+    }
+    return append(content, '\n')
+}
+
+// collapseBlankLines squashes runs of three or more blank lines into one
+func collapseBlankLines(content []byte) []byte {
+    lines := bytes.Split(content, []byte("\n"))
+    var kept, blanks [][]byte
+    flush := func() {
+        if len(blanks) >= 3 {
+            blanks = blanks[:1]
         }
+        kept = append(kept, blanks...)
+        blanks = nil
+    }
 
-        // Ignore files with specific extensions (e.g., binaries)
-        if !info.IsDir() && hasIgnoredExtension(path, config.IgnoredFileTypes) {
-            return nil // Skip this file type
+    for i, line := range lines {
+        // The empty string after a final newline isn't a blank line
+        if len(bytes.TrimSpace(line)) == 0 && i < len(lines)-1 {
+            blanks = append(blanks, line)
+            continue
         }
+        flush()
+        kept = append(kept, line)
+    }
+    return bytes.Join(kept, []byte("\n"))
+}
 
-        // Process only files in subdirectories
-        if !info.IsDir() {
-            content, err := os.ReadFile(path)
-            if err != nil {
-                return err
-            }
+// renderTree draws the given relative paths as an ASCII directory tree
+func renderTree(relPaths []string) string {
+    type treeNode struct {
+        name     string
+        children []*treeNode
+    }
 
-            // Ensure output file exists and doesn't exceed the max size
-            if outputFile == nil || currentFileSize+len(content) > config.MaxFileSizeMB*MB {
-                if outputFile != nil {
-                    outputFile.Close()
-                }
-                outputFile, err = createNewOutputFile(outputFolder, outputFileIndex)
-                if err != nil {
-                    return err
+    root := &treeNode{name: "."}
+    for _, relPath := range relPaths {
+        node := root
+        for _, segment := range strings.Split(filepath.ToSlash(relPath), "/") {
+            var child *treeNode
+            for _, existing := range node.children {
+                if existing.name == segment {
+                    child = existing
+                    break
                 }
-                outputFileIndex++
-                currentFileSize = 0
             }
-
-            // Write file path as a comment and append the content
-            relPath, _ := filepath.Rel(selectedProject, path)
-            writeFileWithComment(outputFile, relPath, content)
-            currentFileSize += len(content)
+            if child == nil {
+                child = &treeNode{name: segment}
+                node.children = append(node.children, child)
+            }
+            node = child
         }
-        return nil
-    })
-
-    if err != nil {
-        fmt.Println("Error processing project:", err)
     }
 
-    if outputFile != nil {
-        outputFile.Close()
+    var builder strings.Builder
+    builder.WriteString(root.name + "\n")
+
+    var render func(node *treeNode, indent string)
+    render = func(node *treeNode, indent string) {
+        for i, child := range node.children {
+            branch, nextIndent := "├── ", indent+"│   "
+            if i == len(node.children)-1 {
+                branch, nextIndent = "└── ", indent+"    "
+            }
+            builder.WriteString(indent + branch + child.name + "\n")
+            render(child, nextIndent)
+        }
     }
+    render(root, "")
 
-    fmt.Println("Merging complete.")
+    return builder.String()
 }
 
-func loadConfig(configPath string) (Config, error) {
-    content, err := os.ReadFile(configPath)
-    if err != nil {
-        return Config{}, err
+// buildFooter returns the text closing the last output file: the footer
+// and, with footer_summary, a count and tree of the merged files
+func buildFooter(config Config, mergedPaths []string) string {
+    footer := config.Footer
+    if footer != "" && !strings.HasSuffix(footer, "\n") {
+        footer += "\n"
+    }
+    if config.FooterSummary && len(mergedPaths) > 0 {
+        if footer != "" {
+            footer += "\n"
+        }
+        summary := fmt.Sprintf("Merged %d files:\n", len(mergedPaths))
+        if config.OutputFormat == "markdown" {
+            summary = "## Summary\n\n" + summary + "\n```\n" + renderTree(mergedPaths) + "```\n"
+        } else {
+            summary += renderTree(mergedPaths)
+        }
+        footer += summary
     }
+    return footer
+}
 
-    var config Config
-    err = json.Unmarshal(content, &config)
-    if err != nil {
-        return Config{}, err
+func writeTree(writer io.Writer, tree string, format string) {
+    if format == "markdown" {
+        io.WriteString(writer, "## Project structure\n\n```\n"+tree+"```\n\n")
+        return
     }
+    io.WriteString(writer, tree+"\n")
+}
 
-    return config, nil
+// headerLabel is the text naming a file in its header, optionally followed
+// by its size and modification time
+func headerLabel(relPath string, info fs.FileInfo, config Config) string {
+    if !config.HeaderMetadata || info == nil {
+        return relPath
+    }
+    return fmt.Sprintf("%s (%d bytes, modified %s)", relPath, info.Size(), info.ModTime().Format("2006-01-02 15:04"))
 }
 
-func expandPath(path string) string {
-    if strings.HasPrefix(path, "~") {
-        homeDir, _ := os.UserHomeDir()
-        return filepath.Join(homeDir, path[1:])
+// commentPrefixFor returns the comment delimiters for the language of path,
+// so the path header stays a valid comment in the merged output
+func commentPrefixFor(path string) (prefix, suffix string) {
+    switch strings.ToLower(filepath.Ext(path)) {
+    case ".py", ".sh", ".bash", ".yml", ".yaml", ".toml", ".rb":
+        return "#", ""
+    case ".sql":
+        return "--", ""
+    case ".html", ".htm", ".xml", ".md":
+        return "<!--", "-->"
+    case ".css":
+        return "/*", "*/"
+    default:
+        return "//", ""
     }
-    return path
 }
 
-func cleanOutputDirectory(outputDir string) error {
-    // Remove the entire output directory and its contents
-    err := os.RemoveAll(outputDir)
-    if err != nil && !os.IsNotExist(err) {
+const jsonOutputFileName = "output.json"
+
+type jsonEntry struct {
+    Path    string `json:"path"`
+    Content string `json:"content"`
+}
+
+// writeFileAsJSON writes one element of the --json array, on a single line
+func writeFileAsJSON(writer io.Writer, relPath string, content []byte, config Config) error {
+    var encoded bytes.Buffer
+    encoder := json.NewEncoder(&encoded)
+    encoder.SetEscapeHTML(false)
+    if err := encoder.Encode(jsonEntry{Path: filepath.ToSlash(relPath), Content: string(content)}); err != nil {
         return err
     }
-
-    // Recreate the output directory
-    return os.MkdirAll(outputDir, os.ModePerm)
+    _, err := writer.Write(bytes.TrimSuffix(encoded.Bytes(), []byte("\n")))
+    return err
 }
 
-func findTopLevelNodeProjects(rootFolder string) ([]string, error) {
-    var projects []string
-    entries, err := os.ReadDir(rootFolder)
-    if err != nil {
-        return projects, err
+func writeFileAsMarkdown(writer io.Writer, language string, heading string, content []byte, separator string) {
+    fence := markdownFence(content)
+
+    io.WriteString(writer, heading+"\n\n")
+    io.WriteString(writer, fence+language+"\n")
+    writer.Write(content)
+    if len(content) > 0 && content[len(content)-1] != '\n' {
+        io.WriteString(writer, "\n")
     }
+    io.WriteString(writer, fence+separator)
+}
 
-    for _, entry := range entries {
-        if entry.IsDir() {
-            packagePath := filepath.Join(rootFolder, entry.Name(), "package.json")
-            if _, err := os.Stat(packagePath); err == nil {
-                projects = append(projects, filepath.Join(rootFolder, entry.Name()))
+// markdownFence returns a backtick fence longer than any backtick run in content
+func markdownFence(content []byte) string {
+    longest, run := 0, 0
+    for _, b := range content {
+        if b == '`' {
+            run++
+            if run > longest {
+                longest = run
             }
+        } else {
+            run = 0
         }
     }
+    if longest < 3 {
+        return "```"
+    }
+    return strings.Repeat("`", longest+1)
+}
 
-    return projects, nil
+var languagesByExtension = map[string]string{
+    ".bash":  "bash",
+    ".c":     "c",
+    ".cpp":   "cpp",
+    ".cs":    "csharp",
+    ".css":   "css",
+    ".go":    "go",
+    ".h":     "c",
+    ".html":  "html",
+    ".java":  "java",
+    ".js":    "js",
+    ".json":  "json",
+    ".jsx":   "jsx",
+    ".kt":    "kotlin",
+    ".md":    "markdown",
+    ".mjs":   "js",
+    ".php":   "php",
+    ".py":    "python",
+    ".rb":    "ruby",
+    ".rs":    "rust",
+    ".scss":  "scss",
+    ".sh":    "sh",
+    ".sql":   "sql",
+    ".swift": "swift",
+    ".toml":  "toml",
+    ".ts":    "ts",
+    ".tsx":   "tsx",
+    ".xml":   "xml",
+    ".yaml":  "yaml",
+    ".yml":   "yaml",
 }
 
-func isBlacklisted(path string, blacklistedFolders []string) bool {
-    for _, folder := range blacklistedFolders {
-        if strings.Contains(path, folder) {
-            return true
-        }
+// languageFor looks up the language of a file, preferring the configured
+// language_map over the built-in table
+func languageFor(path string, overrides map[string]string) string {
+    ext := strings.ToLower(filepath.Ext(path))
+    if language, ok := overrides[ext]; ok {
+        return language
     }
-    return false
+    return languagesByExtension[ext]
 }
 
-func createNewOutputFile(outputDir string, index int) (*os.File, error) {
-    fileName := fmt.Sprintf("%d.txt", index)
-    outputPath := filepath.Join(outputDir, fileName)
-    return os.Create(outputPath)
+// fzfPreview shows the name and description from a project's package.json
+const fzfPreview = `grep -E '"(name|description)"' {}/package.json 2>/dev/null`
+
+// selectProjectsWithFzf lets the user pick projects with fzf, passing the
+// configured fzf_args along. Should fzf reject them, it is run again with
+// the default options.
+func selectProjectsWithFzf(projects []string, fzfArgs []string) ([]string, error) {
+    args := append([]string{"--multi"}, sanitizeFzfArgs(fzfArgs)...)
+    hasPreview := false
+    for _, arg := range args {
+        if arg == "--preview" || strings.HasPrefix(arg, "--preview=") {
+            hasPreview = true
+        }
+    }
+    if !hasPreview {
+        args = append(args, "--preview", fzfPreview)
+    }
+
+    selected, err := runFzf(projects, args)
+    var exitErr *exec.ExitError
+    if len(fzfArgs) > 0 && errors.As(err, &exitErr) && exitErr.ExitCode() == 2 {
+        warnf("fzf rejected fzf_args, retrying without them")
+        selected, err = runFzf(projects, []string{"--multi", "--preview", fzfPreview})
+    }
+    return selected, err
 }
 
-func writeFileWithComment(outputFile *os.File, relPath string, content []byte) {
-    if startsWithComment(content) {
-        fmt.Printf("Warning: The file %s starts with a comment.\n", relPath)
+// sanitizeFzfArgs drops fzf_args that aren't options or that could run
+// commands other than a preview, such as execute actions in --bind. An
+// option's value may follow it as a separate entry.
+func sanitizeFzfArgs(fzfArgs []string) []string {
+    unsafe := func(arg string) bool {
+        lower := strings.ToLower(arg)
+        return strings.ContainsAny(arg, "\n\r") || lower == "--bind" || strings.HasPrefix(lower, "--bind=") ||
+            strings.Contains(lower, "execute") || strings.Contains(lower, "become")
     }
 
-    writer := bufio.NewWriter(outputFile)
-    writer.WriteString("// " + relPath + "\n")
-    writer.Write(content)
-    writer.WriteString("\n\n")
-    writer.Flush()
+    var args []string
+    for i := 0; i < len(fzfArgs); i++ {
+        option := []string{fzfArgs[i]}
+        if i+1 < len(fzfArgs) && !strings.HasPrefix(fzfArgs[i+1], "-") {
+            option = append(option, fzfArgs[i+1])
+            i++
+        }
+        if !strings.HasPrefix(option[0], "-") || unsafe(option[0]) || unsafe(option[len(option)-1]) {
+            warnf("ignoring fzf_args entry %q", strings.Join(option, " "))
+            continue
+        }
+        args = append(args, option...)
+    }
+    return args
 }
 
-func selectProjectWithFzf(projects []string) (string, error) {
-    cmd := exec.Command("fzf")
+func runFzf(projects []string, args []string) ([]string, error) {
+    cmd := exec.Command("fzf", args...)
+    cmd.Stderr = os.Stderr
 
     stdin, err := cmd.StdinPipe()
     if err != nil {
-        return "", err
+        return nil, err
     }
 
     go func() {
@@ -241,10 +3414,164 @@ func selectProjectWithFzf(projects []string) (string, error) {
 
     output, err := cmd.Output()
     if err != nil {
-        return "", err
+        return nil, err
+    }
+
+    var selected []string
+    for _, line := range strings.Split(string(output), "\n") {
+        if line = strings.TrimSpace(line); line != "" {
+            selected = append(selected, line)
+        }
+    }
+    return selected, nil
+}
+
+// readPackageMeta returns the package name and sorted script names from the
+// package.json of a project. Both are empty when there is no package.json.
+func readPackageMeta(projectDir string) (name string, scripts []string, err error) {
+    content, err := os.ReadFile(filepath.Join(projectDir, "package.json"))
+    if os.IsNotExist(err) {
+        return "", nil, nil
+    }
+    if err != nil {
+        return "", nil, err
+    }
+
+    var pkg struct {
+        Name    string            `json:"name"`
+        Scripts map[string]string `json:"scripts"`
+    }
+    if err := json.Unmarshal(content, &pkg); err != nil {
+        return "", nil, err
+    }
+    for script := range pkg.Scripts {
+        scripts = append(scripts, script)
+    }
+    sort.Strings(scripts)
+    return pkg.Name, scripts, nil
+}
+
+// listSubdirs returns the immediate subfolders of a project that aren't
+// blacklisted
+func listSubdirs(project string, config Config) ([]string, error) {
+    entries, err := os.ReadDir(project)
+    if err != nil {
+        return nil, err
+    }
+
+    var subdirs []string
+    for _, entry := range entries {
+        if entry.IsDir() && !isBlacklisted(entry.Name(), config.BlacklistedFolders, true, config.CaseInsensitive) {
+            subdirs = append(subdirs, filepath.Join(project, entry.Name()))
+        }
+    }
+    return subdirs, nil
+}
+
+// readProjectList reads newline-separated project paths, such as the output
+// of find or fd. Paths that aren't directories are skipped with a warning.
+func readProjectList(reader io.Reader) ([]string, error) {
+    var projects []string
+    seen := map[string]bool{}
+    scanner := bufio.NewScanner(reader)
+    for scanner.Scan() {
+        line := strings.TrimSpace(scanner.Text())
+        if line == "" {
+            continue
+        }
+
+        path, err := expandPath(line)
+        if err == nil {
+            path, err = filepath.Abs(path)
+        }
+        if err != nil {
+            warnf("skipping project %s: %v", line, err)
+            continue
+        }
+        if info, err := os.Stat(path); err != nil || !info.IsDir() {
+            warnf("skipping project %s, it isn't a directory", line)
+            continue
+        }
+
+        if !seen[path] {
+            seen[path] = true
+            projects = append(projects, path)
+        }
+    }
+    return projects, scanner.Err()
+}
+
+// selectProjectsFromMenu lists the projects with numbers and reads the
+// chosen numbers (separated by spaces or commas) from stdin
+func selectProjectsFromMenu(stdin *bufio.Reader, projects []string) ([]string, error) {
+    for i, project := range projects {
+        fmt.Fprintf(logOutput, "%3d) %s\n", i+1, project)
+    }
+    fmt.Fprint(logOutput, "Select project(s): ")
+
+    answer, err := stdin.ReadString('\n')
+    if err != nil && answer == "" {
+        return nil, fmt.Errorf("reading selection: %w", err)
+    }
+
+    var selected []string
+    for _, field := range strings.FieldsFunc(answer, func(r rune) bool { return r == ',' || r == ' ' || r == '\t' || r == '\n' || r == '\r' }) {
+        var index int
+        if _, err := fmt.Sscanf(field, "%d", &index); err != nil || index < 1 || index > len(projects) {
+            return nil, fmt.Errorf("invalid selection %q, expected a number from 1 to %d", field, len(projects))
+        }
+        selected = append(selected, projects[index-1])
+    }
+    return selected, nil
+}
+
+// findProject matches name against the discovered projects by basename or path
+func findProject(projects []string, name string) (string, bool) {
+    absName, err := expandPath(name)
+    if err == nil {
+        absName, err = filepath.Abs(absName)
+    }
+    if err != nil {
+        absName = name
+    }
+
+    for _, project := range projects {
+        if filepath.Base(project) == name || project == absName {
+            return project, true
+        }
+    }
+    return "", false
+}
+
+// copyToClipboard pipes content into the first available clipboard utility
+// and returns its name
+func copyToClipboard(content []byte) (string, error) {
+    candidates := [][]string{
+        {"pbcopy"},
+        {"wl-copy"},
+        {"xclip", "-selection", "clipboard"},
+        {"xsel", "--clipboard", "--input"},
+        {"clip"},
+    }
+    if os.Getenv("WAYLAND_DISPLAY") == "" {
+        // wl-copy only works inside a Wayland session
+        candidates = append(candidates[:1], candidates[2:]...)
+    }
+
+    for _, candidate := range candidates {
+        if _, err := exec.LookPath(candidate[0]); err != nil {
+            continue
+        }
+
+        cmd := exec.Command(candidate[0], candidate[1:]...)
+        cmd.Stdin = bytes.NewReader(content)
+        if err := cmd.Run(); err != nil {
+            return "", fmt.Errorf("%s: %w", candidate[0], err)
+        }
+        return candidate[0], nil
     }
 
-    return strings.TrimSpace(string(output)), nil
+    return "", fmt.Errorf("no clipboard utility found (install pbcopy, xclip, xsel or wl-copy)")
 }
 
 func isInRoot(rootFolder string, filePath string) bool {
@@ -256,15 +3583,217 @@ func isInRoot(rootFolder string, filePath string) bool {
     return !strings.Contains(relativePath, string(os.PathSeparator))
 }
 
-func hasIgnoredExtension(filePath string, ignoredExtensions []string) bool {
-    for _, ext := range ignoredExtensions {
-        if strings.HasSuffix(filePath, ext) {
+func matchesAnyRegex(relPath string, patterns []*regexp.Regexp) bool {
+    for _, re := range patterns {
+        if re.MatchString(relPath) {
+            return true
+        }
+    }
+    return false
+}
+
+func isIncludedRootFile(name string, includeRootFiles []string) bool {
+    for _, pattern := range includeRootFiles {
+        if ok, _ := filepath.Match(pattern, name); ok {
+            return true
+        }
+    }
+    return false
+}
+
+func hasIgnoredExtension(filePath string, ignoredExtensions []string, caseInsensitive bool) bool {
+    return hasAnySuffix(filePath, ignoredExtensions, caseInsensitive)
+}
+
+func hasAllowedExtension(filePath string, allowedExtensions []string, caseInsensitive bool) bool {
+    return hasAnySuffix(filePath, allowedExtensions, caseInsensitive)
+}
+
+func hasAnySuffix(filePath string, suffixes []string, caseInsensitive bool) bool {
+    if caseInsensitive {
+        filePath = strings.ToLower(filePath)
+    }
+    for _, suffix := range suffixes {
+        if caseInsensitive {
+            suffix = strings.ToLower(suffix)
+        }
+        if strings.HasSuffix(filePath, suffix) {
+            return true
+        }
+    }
+    return false
+}
+
+func matchesInclude(relPath string, patterns []string) bool {
+    relPath = filepath.ToSlash(relPath)
+    for _, pattern := range patterns {
+        // Patterns without a slash match the file name in any directory
+        name := relPath
+        if !strings.Contains(pattern, "/") {
+            name = filepath.Base(relPath)
+        }
+        if matchGlob(pattern, name) {
             return true
         }
     }
     return false
 }
 
+// estimateTokens approximates a token count by averaging the
+// characters/4 and whitespace-separated word count heuristics
+func estimateTokens(content []byte) int {
+    words := 0
+    inWord := false
+    for _, b := range content {
+        if b == ' ' || b == '\t' || b == '\n' || b == '\r' || b == '\v' || b == '\f' {
+            inWord = false
+        } else if !inWord {
+            inWord = true
+            words++
+        }
+    }
+    return (len(content)/4 + words) / 2
+}
+
+// hiddenFlags are accepted but left out of --help
+var hiddenFlags = map[string]bool{"cpuprofile": true, "memprofile": true}
+
+func printUsage() {
+    output := flag.CommandLine.Output()
+    fmt.Fprintf(output, "Usage of %s:\n", os.Args[0])
+
+    visible := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+    visible.SetOutput(output)
+    flag.VisitAll(func(f *flag.Flag) {
+        if !hiddenFlags[f.Name] {
+            visible.Var(f.Value, f.Name, f.Usage)
+        }
+    })
+    visible.PrintDefaults()
+}
+
+func printVersion() {
+    fmt.Printf("filemerge %s\n", version)
+    fmt.Printf("  go: %s %s/%s\n", runtime.Version(), runtime.GOOS, runtime.GOARCH)
+
+    info, ok := debug.ReadBuildInfo()
+    if !ok {
+        return
+    }
+    for _, setting := range info.Settings {
+        switch setting.Key {
+        case "vcs.revision":
+            fmt.Printf("  commit: %s\n", setting.Value)
+        case "vcs.modified":
+            if setting.Value == "true" {
+                fmt.Println("  modified: true")
+            }
+        }
+    }
+}
+
+// printLargestFiles lists the n largest merged files by the bytes they
+// took up in the output
+func printLargestFiles(relPaths []string, sizes []int, n int) {
+    if n <= 0 || len(relPaths) == 0 {
+        return
+    }
+
+    order := make([]int, len(relPaths))
+    for i := range order {
+        order[i] = i
+    }
+    sort.SliceStable(order, func(a, b int) bool { return sizes[order[a]] > sizes[order[b]] })
+    if len(order) > n {
+        order = order[:n]
+    }
+
+    infof("Largest files:")
+    for _, i := range order {
+        infof("  %10d  %s", sizes[i], filepath.ToSlash(relPaths[i]))
+    }
+}
+
+// printExtensionStats prints the file count and total size per extension,
+// largest first
+func printExtensionStats(files []sourceFile) {
+    type extensionStats struct {
+        extension string
+        files     int
+        bytes     int64
+    }
+
+    byExtension := map[string]*extensionStats{}
+    var totalBytes int64
+    for _, file := range files {
+        extension := strings.ToLower(filepath.Ext(file.relPath))
+        if extension == "" {
+            extension = "(none)"
+        }
+        if byExtension[extension] == nil {
+            byExtension[extension] = &extensionStats{extension: extension}
+        }
+        byExtension[extension].files++
+        byExtension[extension].bytes += file.info.Size()
+        totalBytes += file.info.Size()
+    }
+
+    var rows []*extensionStats
+    for _, row := range byExtension {
+        rows = append(rows, row)
+    }
+    sort.Slice(rows, func(i, j int) bool {
+        if rows[i].bytes != rows[j].bytes {
+            return rows[i].bytes > rows[j].bytes
+        }
+        return rows[i].extension < rows[j].extension
+    })
+
+    infof("%-12s %8s %12s %7s", "Extension", "Files", "Bytes", "Share")
+    for _, row := range rows {
+        share := 0.0
+        if totalBytes > 0 {
+            share = float64(row.bytes) * 100 / float64(totalBytes)
+        }
+        infof("%-12s %8d %12d %6.1f%%", row.extension, row.files, row.bytes, share)
+    }
+    infof("%-12s %8d %12d", "total", len(files), totalBytes)
+}
+
+func printTokenSummary(outputTokens []int, outputNames []string) {
+    total := 0
+    infof("Estimated tokens:")
+    for i, tokens := range outputTokens {
+        infof("  %s: %d", outputNames[i], tokens)
+        total += tokens
+    }
+    infof("  total: %d", total)
+}
+
+// detectBinary sniffs the start of content for NUL bytes or a high ratio
+// of control characters
+func detectBinary(content []byte) bool {
+    sample := content
+    if len(sample) > 8*1024 {
+        sample = sample[:8*1024]
+    }
+    if len(sample) == 0 {
+        return false
+    }
+
+    nonPrintable := 0
+    for _, b := range sample {
+        switch {
+        case b == 0:
+            return true
+        case b == '\n' || b == '\r' || b == '\t' || b == '\f' || b == '\b' || b == 0x1b:
+        case b < 0x20 || b == 0x7f:
+            nonPrintable++
+        }
+    }
+    return nonPrintable*10 > len(sample)*3
+}
+
 func startsWithComment(content []byte) bool {
     trimmedContent := strings.TrimSpace(string(content))
     return strings.HasPrefix(trimmedContent, "//") || strings.HasPrefix(trimmedContent, "/*") || strings.HasPrefix(trimmedContent, "#")
@@ -272,7 +3801,7 @@ func startsWithComment(content []byte) bool {
 
 func resolveRelativePath(basePath, relativePath string) string {
     if filepath.IsAbs(relativePath) {
-        return relativePath
+        return filepath.Clean(relativePath)
     }
     return filepath.Join(basePath, relativePath)
 }
@@ -0,0 +1,203 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "os"
+    "path/filepath"
+    "reflect"
+    "strings"
+    "testing"
+)
+
+func TestIsBlacklisted(t *testing.T) {
+    tests := []struct {
+        name    string
+        folders []string
+        relPath string
+        isDir   bool
+        want    bool
+    }{
+        {"folder at any depth", []string{"node_modules"}, "a/node_modules", true, true},
+        {"file below folder", []string{"node_modules"}, "node_modules/x/index.js", false, true},
+        {"file named like folder", []string{"build"}, "src/build", false, false},
+        {"anchored folder in root", []string{"/dist"}, "dist", true, true},
+        {"anchored folder nested", []string{"/dist"}, "src/dist", true, false},
+        {"file below anchored folder", []string{"/dist"}, "dist/a.js", false, true},
+        {"multi-segment entry", []string{"src/gen"}, "app/src/gen/x.ts", false, true},
+        {"glob entry", []string{"*.egg-info"}, "pkg.egg-info", true, true},
+        {"double star entry", []string{"docs/**/tmp"}, "docs/a/b/tmp", true, true},
+        {"negated file under excluded parent", []string{"logs", "!logs/keep.log"}, "logs/keep.log", false, false},
+        {"other files under excluded parent", []string{"logs", "!logs/keep.log"}, "logs/a.log", false, true},
+        {"negated folder under excluded parent", []string{"vendor", "!vendor/ours"}, "vendor/ours/a.go", false, false},
+        {"last match wins", []string{"!logs/keep.log", "logs"}, "logs/keep.log", false, true},
+        {"unrelated path", []string{"logs"}, "src/a.ts", false, false},
+    }
+
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            if got := isBlacklisted(test.relPath, test.folders, test.isDir, false); got != test.want {
+                t.Errorf("isBlacklisted(%q, %q) = %v, want %v", test.relPath, test.folders, got, test.want)
+            }
+        })
+    }
+}
+
+func TestIsBlacklistedCaseInsensitive(t *testing.T) {
+    if isBlacklisted("Node_Modules", []string{"node_modules"}, true, false) {
+        t.Error("case-sensitive match ignored case")
+    }
+    if !isBlacklisted("Node_Modules", []string{"node_modules"}, true, true) {
+        t.Error("case-insensitive match didn't ignore case")
+    }
+}
+
+func TestMayReinclude(t *testing.T) {
+    tests := []struct {
+        folders []string
+        relPath string
+        want    bool
+    }{
+        {[]string{"logs", "!logs/keep.log"}, "logs", true},
+        {[]string{"logs", "!logs/keep.log"}, "src/logs", true},
+        {[]string{"logs", "!/logs/keep.log"}, "src/logs", false},
+        {[]string{"vendor", "!vendor/ours/x"}, "vendor/ours", true},
+        {[]string{"logs", "!other/keep.log"}, "logs", false},
+        {[]string{"logs", "!**/keep.log"}, "logs", true},
+        {[]string{"logs"}, "logs", false},
+    }
+
+    for _, test := range tests {
+        if got := mayReinclude(test.relPath, test.folders, false); got != test.want {
+            t.Errorf("mayReinclude(%q, %q) = %v, want %v", test.relPath, test.folders, got, test.want)
+        }
+    }
+}
+
+func TestMergeConfigsOverridesWithZeroValues(t *testing.T) {
+    base := Config{SkipHidden: true, RespectGitignore: true, MaxFileSizeMB: 5, BlacklistedFolders: []string{"node_modules"}}
+    var override Config
+    override.SkipHidden = false
+    override.BlacklistedFolders = []string{"dist"}
+    override.setKeys = map[string]bool{"skip_hidden": true, "blacklisted_folders": true}
+
+    merged := mergeConfigs(base, override)
+    if merged.SkipHidden {
+        t.Error("skip_hidden set to false in the override was ignored")
+    }
+    if !merged.RespectGitignore || merged.MaxFileSizeMB != 5 {
+        t.Errorf("settings missing from the override changed: %+v", merged)
+    }
+    if len(merged.BlacklistedFolders) != 2 {
+        t.Errorf("blacklisted_folders = %q, want both lists", merged.BlacklistedFolders)
+    }
+}
+
+func TestDefaultConfigDocumentsEveryKey(t *testing.T) {
+    configType := reflect.TypeOf(Config{})
+    for i := 0; i < configType.NumField(); i++ {
+        key := configKey(configType.Field(i))
+        if key != "" && !strings.Contains(defaultConfig, `"`+key+`"`) {
+            t.Errorf("defaultConfig doesn't mention %q", key)
+        }
+    }
+
+    content, err := stripJSONComments([]byte(defaultConfig))
+    if err != nil || !json.Valid(content) {
+        t.Errorf("defaultConfig isn't valid JSONC: %v", err)
+    }
+}
+
+// mergeMirror merges project into outputFolder with the mirror strategy
+func mergeMirror(t *testing.T, project string, outputFolder string, extra string) {
+    t.Helper()
+    configPath := filepath.Join(t.TempDir(), "config.json")
+    content := fmt.Sprintf(`{"root_folder": %q, "output_folder": %q, "max_file_size_mb": 5, "split_strategy": "mirror"%s}`, filepath.Dir(project), outputFolder, extra)
+    if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+        t.Fatal(err)
+    }
+
+    level := currentLogLevel
+    currentLogLevel = levelError
+    defer func() { currentLogLevel = level }()
+
+    config, err := prepareConfig([]string{configPath}, filepath.Dir(configPath), "")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if err := mergeProjects(context.Background(), []string{project}, config, config.OutputFolder, mergeOptions{}); err != nil {
+        t.Fatal(err)
+    }
+}
+
+func TestMirrorKeepsSourcePaths(t *testing.T) {
+    project := filepath.Join(t.TempDir(), "app")
+    if err := os.MkdirAll(filepath.Join(project, "src", "lib"), 0755); err != nil {
+        t.Fatal(err)
+    }
+    os.WriteFile(filepath.Join(project, "src", "foo.py"), []byte("print(1)\n"), 0644)
+    os.WriteFile(filepath.Join(project, "src", "lib", "util.ts"), []byte("export {}\n"), 0644)
+    outputFolder := filepath.Join(t.TempDir(), "out")
+
+    mergeMirror(t, project, outputFolder, "")
+
+    content, err := os.ReadFile(filepath.Join(outputFolder, "src", "foo.py"))
+    if err != nil {
+        t.Fatalf("mirrored file missing: %v", err)
+    }
+    if want := "# src/foo.py\nprint(1)\n"; !strings.HasPrefix(string(content), want) {
+        t.Errorf("out/src/foo.py = %q, want it to start with %q", content, want)
+    }
+    if _, err := os.Stat(filepath.Join(outputFolder, "src", "lib", "util.ts")); err != nil {
+        t.Errorf("nested mirrored file missing: %v", err)
+    }
+    if _, err := os.Stat(filepath.Join(outputFolder, "src", "foo.py.txt")); !os.IsNotExist(err) {
+        t.Error("mirrored file got the output_file_pattern extension")
+    }
+}
+
+func TestMirrorCleanOnlyGenerated(t *testing.T) {
+    project := filepath.Join(t.TempDir(), "app")
+    os.MkdirAll(filepath.Join(project, "src", "old"), 0755)
+    os.WriteFile(filepath.Join(project, "src", "foo.py"), []byte("print(1)\n"), 0644)
+    os.WriteFile(filepath.Join(project, "src", "old", "gone.py"), []byte("print(2)\n"), 0644)
+    outputFolder := filepath.Join(t.TempDir(), "out")
+    mergeMirror(t, project, outputFolder, `, "clean_only_generated": true`)
+
+    // A file the tool didn't write survives, a removed source doesn't
+    notes := filepath.Join(outputFolder, "src", "notes.md")
+    os.WriteFile(notes, []byte("mine\n"), 0644)
+    os.RemoveAll(filepath.Join(project, "src", "old"))
+    mergeMirror(t, project, outputFolder, `, "clean_only_generated": true`)
+
+    if _, err := os.Stat(notes); err != nil {
+        t.Errorf("file not written by the tool was removed: %v", err)
+    }
+    if _, err := os.Stat(filepath.Join(outputFolder, "src", "old")); !os.IsNotExist(err) {
+        t.Error("folder of a removed source file was kept")
+    }
+    if _, err := os.Stat(filepath.Join(outputFolder, "src", "foo.py")); err != nil {
+        t.Errorf("mirrored file missing after the second run: %v", err)
+    }
+
+    foreign, err := hasForeignFiles(outputFolder, "%d.txt", true, map[string]bool{"src/foo.py": true})
+    if err != nil || !foreign {
+        t.Errorf("hasForeignFiles = %v, %v, want notes.md reported", foreign, err)
+    }
+}
+
+func TestProjectConfigRejectsCommands(t *testing.T) {
+    for _, key := range []string{"transforms", "post_merge_command", "editor"} {
+        project := t.TempDir()
+        content := fmt.Sprintf(`{%q: {".ts": "touch pwned; cat"}}`, key)
+        if key != "transforms" {
+            content = fmt.Sprintf(`{%q: "touch pwned"}`, key)
+        }
+        os.WriteFile(filepath.Join(project, projectConfigName), []byte(content), 0644)
+
+        if _, err := loadProjectConfig(project, Config{}); err == nil || !strings.Contains(err.Error(), key) {
+            t.Errorf("%s in %s: error = %v, want it rejected", key, projectConfigName, err)
+        }
+    }
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+    "encoding/json"
+    "io"
+    "os"
+    "path/filepath"
+)
+
+type ManifestEntry struct {
+    Path       string `json:"path"`
+    Size       int    `json:"size"`
+    SHA256     string `json:"sha256"`
+    OutputFile string `json:"output_file"`
+    StartLine  int    `json:"start_line"`
+    EndLine    int    `json:"end_line"`
+}
+
+func writeManifest(outputFolder string, entries []ManifestEntry) error {
+    content, err := json.MarshalIndent(entries, "", "  ")
+    if err != nil {
+        return err
+    }
+    return os.WriteFile(filepath.Join(outputFolder, "manifest.json"), append(content, '\n'), 0644)
+}
+
+// lineCounter tracks the lines written to an output file so manifest
+// entries can point at the region each source file occupies
+type lineCounter struct {
+    w        io.Writer
+    lines    int // number of completed lines
+    lastText int // line number of the last line holding any text
+}
+
+func (c *lineCounter) Write(p []byte) (int, error) {
+    n, err := c.w.Write(p)
+    for _, b := range p[:n] {
+        if b == '\n' {
+            c.lines++
+        } else {
+            c.lastText = c.lines + 1
+        }
+    }
+    return n, err
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+    "os"
+    "time"
+)
+
+type readResult struct {
+    content []byte
+    err     error
+    retries []error // failed attempts before the final one
+}
+
+// readFilesInOrder reads files with a pool of workers and hands each result
+// to consume in the original order of files. Reads never run more than a
+// small window ahead of consume, which keeps memory bounded on large projects.
+// Failed reads are retried up to retries times.
+func readFilesInOrder(files []sourceFile, workers int, retries int, consume func(file sourceFile, content []byte, err error) error) error {
+    if workers < 1 {
+        workers = 1
+    }
+
+    results := make([]chan readResult, len(files))
+    for i := range results {
+        results[i] = make(chan readResult, 1)
+    }
+
+    jobs := make(chan int)
+    done := make(chan struct{})
+    defer close(done)
+
+    window := make(chan struct{}, workers*2)
+    go func() {
+        defer close(jobs)
+        for i := range files {
+            select {
+            case window <- struct{}{}:
+            case <-done:
+                return
+            }
+            select {
+            case jobs <- i:
+            case <-done:
+                return
+            }
+        }
+    }()
+
+    for w := 0; w < workers; w++ {
+        go func() {
+            for i := range jobs {
+                results[i] <- readFileWithRetry(files[i], retries)
+            }
+        }()
+    }
+
+    for i, file := range files {
+        result := <-results[i]
+        <-window
+        for attempt, retryErr := range result.retries {
+            debugf("retrying read of %s (%d/%d): %v", file.relPath, attempt+1, retries, retryErr)
+        }
+        if err := consume(file, result.content, result.err); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// readFileWithRetry reads a file, retrying with a growing delay on errors
+// that may be transient, such as I/O errors on network filesystems
+func readFileWithRetry(file sourceFile, retries int) readResult {
+    var result readResult
+    for attempt := 0; ; attempt++ {
+        result.content, result.err = readSourceFile(file)
+        if result.err == nil || attempt >= retries || os.IsNotExist(result.err) || os.IsPermission(result.err) {
+            return result
+        }
+        result.retries = append(result.retries, result.err)
+        time.Sleep(time.Duration(attempt+1) * 100 * time.Millisecond)
+    }
+}
+
+// readSourceFile reads a file from the working tree, or from git when it
+// was listed from a ref
+func readSourceFile(file sourceFile) ([]byte, error) {
+    if file.gitRef != "" {
+        return readGitFile(file.project, file.gitRef, file.relPath)
+    }
+    return os.ReadFile(file.path)
+}
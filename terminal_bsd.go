@@ -0,0 +1,17 @@
+//go:build darwin || freebsd || netbsd || openbsd
+
+package main
+
+import (
+    "os"
+    "syscall"
+    "unsafe"
+)
+
+// isTerminal reports whether file is a terminal. Other character devices,
+// such as /dev/null, aren't.
+func isTerminal(file *os.File) bool {
+    var termios syscall.Termios
+    _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, file.Fd(), syscall.TIOCGETA, uintptr(unsafe.Pointer(&termios)))
+    return errno == 0
+}
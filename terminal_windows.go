@@ -0,0 +1,12 @@
+package main
+
+import (
+    "os"
+    "syscall"
+)
+
+// isTerminal reports whether file is a console. NUL isn't.
+func isTerminal(file *os.File) bool {
+    var mode uint32
+    return syscall.GetConsoleMode(syscall.Handle(file.Fd()), &mode) == nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+    "os"
+    "path/filepath"
+)
+
+// walkFollowingSymlinks works like filepath.Walk, except that symlinks are
+// resolved: linked files are reported with their target's info and linked
+// directories are descended into. A directory whose real path is already
+// being walked further up is a cycle; it is reported through onCycle and
+// skipped.
+func walkFollowingSymlinks(root string, fn filepath.WalkFunc, onCycle func(path string)) error {
+    info, err := os.Stat(root)
+    if err != nil {
+        return fn(root, nil, err)
+    }
+
+    active := map[string]bool{}
+    var walk func(path string, info os.FileInfo) error
+    walk = func(path string, info os.FileInfo) error {
+        if !info.IsDir() {
+            return fn(path, info, nil)
+        }
+
+        realPath, err := filepath.EvalSymlinks(path)
+        if err != nil {
+            return fn(path, info, err)
+        }
+        if active[realPath] {
+            onCycle(path)
+            return nil
+        }
+
+        if err := fn(path, info, nil); err != nil {
+            if err == filepath.SkipDir {
+                return nil
+            }
+            return err
+        }
+
+        entries, err := os.ReadDir(path)
+        if err != nil {
+            return fn(path, info, err)
+        }
+
+        active[realPath] = true
+        defer delete(active, realPath)
+
+        for _, entry := range entries {
+            childPath := filepath.Join(path, entry.Name())
+            childInfo, err := os.Stat(childPath)
+            if err != nil {
+                // Dangling symlinks have nothing to merge
+                if entry.Type()&os.ModeSymlink != 0 {
+                    debugf("Skipped %s (broken symlink)", childPath)
+                    continue
+                }
+                if err := fn(childPath, nil, err); err != nil {
+                    return err
+                }
+                continue
+            }
+
+            if err := walk(childPath, childInfo); err != nil {
+                return err
+            }
+        }
+        return nil
+    }
+
+    return walk(root, info)
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+    "context"
+    "io/fs"
+    "os"
+    "path/filepath"
+    "time"
+)
+
+const watchDebounce = 300 * time.Millisecond
+
+type fileState struct {
+    size    int64
+    modTime time.Time
+}
+
+// watchProjects polls the selected projects every interval and calls merge
+// once changes to mergeable files have settled. Polling keeps the tool free
+// of third-party dependencies. Each poll only stats the files; the merge
+// filters, which read ignore files and may run git, are applied once
+// something changed, so edits to blacklisted or ignored files don't trigger
+// a re-merge.
+//
+// Edits to any of configPaths are picked up through reload. An invalid
+// config is reported and the previous one kept. Watching stops when ctx is
+// cancelled.
+func watchProjects(ctx context.Context, projects []string, config Config, configPaths []string, interval time.Duration, reload func() (Config, error), merge func(Config) error) error {
+    stamp, err := statProjects(ctx, projects, config)
+    if err != nil {
+        return err
+    }
+    previous, err := snapshotProjects(ctx, projects, config)
+    if err != nil {
+        return err
+    }
+    configStates := make([]fileState, len(configPaths))
+    for i, configPath := range configPaths {
+        configStates[i] = statFile(configPath)
+    }
+
+    pending := false
+    var lastChange time.Time
+    for {
+        select {
+        case <-ctx.Done():
+            return nil
+        case <-time.After(interval):
+        }
+
+        for i, configPath := range configPaths {
+            state := statFile(configPath)
+            if state == configStates[i] {
+                continue
+            }
+            configStates[i] = state
+            newConfig, err := reload()
+            if err != nil {
+                errorf("%v", err)
+                warnf("keeping the previous config")
+            } else {
+                infof("[%s] Config changed, reloaded %s", time.Now().Format("15:04:05"), configPath)
+                config = newConfig
+                if current, err := snapshotProjects(ctx, projects, config); err == nil {
+                    previous = current
+                }
+                pending = true
+                lastChange = time.Now()
+            }
+        }
+
+        newStamp, err := statProjects(ctx, projects, config)
+        if ctx.Err() != nil {
+            return nil
+        }
+        if err != nil {
+            return err
+        }
+        if sameSnapshot(stamp, newStamp) {
+            if pending && time.Since(lastChange) >= watchDebounce {
+                pending = false
+                infof("[%s] Change detected, re-merging...", time.Now().Format("15:04:05"))
+                if err := merge(config); err != nil {
+                    errorf("%v", err)
+                }
+            }
+            continue
+        }
+        stamp = newStamp
+
+        // Something changed on disk, find out if it affects the merge
+        current, err := snapshotProjects(ctx, projects, config)
+        if ctx.Err() != nil {
+            return nil
+        }
+        if err != nil {
+            return err
+        }
+        if !sameSnapshot(previous, current) {
+            previous = current
+            pending = true
+            lastChange = time.Now()
+        }
+    }
+}
+
+func statFile(path string) fileState {
+    info, err := os.Stat(path)
+    if err != nil {
+        return fileState{}
+    }
+    return fileState{size: info.Size(), modTime: info.ModTime()}
+}
+
+// statProjects records the size and modification time of every file in the
+// projects, leaving out the output folder and blacklisted folders. It is
+// cheap enough to run on every poll.
+func statProjects(ctx context.Context, projects []string, config Config) (map[string]fileState, error) {
+    snapshot := map[string]fileState{}
+    for _, project := range projects {
+        err := filepath.WalkDir(project, func(path string, entry fs.DirEntry, err error) error {
+            if ctx.Err() != nil {
+                return ctx.Err()
+            }
+            if err != nil {
+                return nil // vanished while walking, the next poll sees it
+            }
+            relPath, _ := filepath.Rel(project, path)
+            if entry.IsDir() {
+                if isSkippedPath(path, config.skipPaths) {
+                    return filepath.SkipDir
+                }
+                if relPath != "." && isBlacklisted(relPath, config.BlacklistedFolders, true, config.CaseInsensitive) &&
+                    !mayReinclude(relPath, config.BlacklistedFolders, config.CaseInsensitive) {
+                    return filepath.SkipDir
+                }
+                return nil
+            }
+            if info, err := entry.Info(); err == nil {
+                snapshot[path] = fileState{size: info.Size(), modTime: info.ModTime()}
+            }
+            return nil
+        })
+        if err != nil {
+            return nil, err
+        }
+    }
+    return snapshot, nil
+}
+
+// snapshotProjects records the files that pass the merge filters
+func snapshotProjects(ctx context.Context, projects []string, config Config) (map[string]fileState, error) {
+    snapshot := map[string]fileState{}
+    for _, project := range projects {
+        files, err := collectProjectFiles(ctx, project, config, &mergeStats{silent: true})
+        if err != nil {
+            return nil, err
+        }
+        for _, file := range files {
+            snapshot[file.path] = fileState{size: file.info.Size(), modTime: file.info.ModTime()}
+        }
+    }
+    return snapshot, nil
+}
+
+func sameSnapshot(a, b map[string]fileState) bool {
+    if len(a) != len(b) {
+        return false
+    }
+    for path, state := range a {
+        other, ok := b[path]
+        if !ok || other.size != state.size || !other.modTime.Equal(state.modTime) {
+            return false
+        }
+    }
+    return true
+}